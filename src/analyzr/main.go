@@ -6,21 +6,31 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"path/filepath"
+	"os"
+	"strconv"
+	"strings"
+
+	"rscan"
 
-	"github.com/conformal/btcdb"
-	_ "github.com/conformal/btcdb/ldb"
-	"github.com/conformal/btcec"
 	"github.com/conformal/btcnet"
-	"github.com/conformal/btcscript"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 )
 
 type inData struct {
-	H    int64
-	Tx   int
-	TxIn int
+	H    int64 `json:"height"`
+	Tx   int   `json:"txIndex"`
+	TxIn int   `json:"txIn"`
+	Push int   `json:"push"`
+}
+
+// resultsVersion is the version of the blockchainr.json schema we read.
+const resultsVersion = 1
+
+type results struct {
+	Version    int                  `json:"version"`
+	Net        string               `json:"net"`
+	Duplicates map[string][]*inData `json:"duplicates"`
 }
 
 type rData struct {
@@ -34,46 +44,74 @@ type rData struct {
 	txIn      *btcwire.TxIn
 	txInIndex int
 
-	txPrev         *btcdb.TxListReply
+	txPrev         *btcwire.MsgTx
 	txPrevOut      *btcwire.TxOut
 	txPrevOutIndex uint32
 	blkPrev        *btcutil.Block
+	blkPrevSha     *btcwire.ShaHash
 
-	sigStr []byte
-	pkStr  []byte
-
-	signature *btcec.Signature
-	pubKey    *btcec.PublicKey
-	hash      []byte
+	// Set once the signature is verified
+	*rscan.Verified
 
 	address    string
 	compressed bool
 
-	wif *btcutil.WIF
+	// altAddress and altWif are the forms of the same key with the other
+	// pubkey encoding
+	altAddress string
+
+	wif    *btcutil.WIF
+	altWif *btcutil.WIF
+
+	// status and reason are the columns of the same name in the output
+	status string
+	reason string
+
+	// wallet is the likely wallet family that made the signature
+	wallet string
 }
 
-func btcdbSetup(dataDir, dbType string) (db btcdb.Db, err error) {
-	// Setup database access
-	blockDbNamePrefix := "blocks"
-	dbName := blockDbNamePrefix + "_" + dbType
-	if dbType == "sqlite" {
-		dbName = dbName + ".db"
+// activeNet is the network selected by the flags, used for addresses and
+// WIFs.
+var activeNet = &btcnet.MainNetParams
+
+// verify checks the signature of rd, and fills its address.
+func verify(rd *rData) error {
+	v, err := rscan.Verify(rd.tx.MsgTx(), rd.txInIndex, rd.txPrevOut.PkScript, rd.in.Push)
+	if err != nil {
+		return fmt.Errorf("%v - in %v", err, rd.in)
 	}
-	dbPath := filepath.Join(dataDir, "mainnet", dbName)
+	rd.Verified = v
+	return setAddress(rd)
+}
 
-	db, err = btcdb.OpenDB(dbType, dbPath)
+// setAddress fills the address of rd from its pubkey, and the address of
+// the other encoding of the same pubkey.
+func setAddress(rd *rData) error {
+	aPubKey, err := btcutil.NewAddressPubKey(rd.PkStr, activeNet)
+	if err != nil {
+		return fmt.Errorf("Pubkey parse error: %v", err)
+	}
+	rd.address = aPubKey.EncodeAddress()
+	rd.compressed = aPubKey.Format() == btcutil.PKFCompressed
 
-	return
+	if rd.compressed {
+		aPubKey.SetFormat(btcutil.PKFUncompressed)
+	} else {
+		aPubKey.SetFormat(btcutil.PKFCompressed)
+	}
+	rd.altAddress = aPubKey.EncodeAddress()
+	return nil
 }
 
-func fetch(db btcdb.Db, rd *rData) error {
-	sha, err := db.FetchBlockShaByHeight(rd.in.H)
+func fetch(src rscan.Source, rd *rData) error {
+	blk, err := src.BlockByHeight(rd.in.H)
 	if err != nil {
-		return fmt.Errorf("failed FetchBlockShaByHeight(%v): %v\n", rd.in.H, err)
+		return err
 	}
-	blk, err := db.FetchBlockBySha(sha)
+	sha, err := blk.Sha()
 	if err != nil {
-		return fmt.Errorf("failed FetchBlockBySha(%v) - h %v: %v\n", sha, rd.in.H, err)
+		return err
 	}
 
 	tx := blk.Transactions()[rd.in.Tx]
@@ -84,75 +122,103 @@ func fetch(db btcdb.Db, rd *rData) error {
 	rd.txInIndex = rd.in.TxIn
 	rd.txIn = tx.MsgTx().TxIn[rd.in.TxIn]
 
-	txPrevList, err := db.FetchTxBySha(&rd.txIn.PreviousOutPoint.Hash)
+	txPrev, prevHeight, err := src.TxBySha(&rd.txIn.PreviousOutPoint.Hash)
 	if err != nil {
-		return fmt.Errorf("failed FetchTxBySha(%v) - h %v: %v\n",
-			rd.txIn.PreviousOutPoint.Hash, rd.in.H, err)
+		return fmt.Errorf("h %v: %v\n", rd.in.H, err)
 	}
 
-	if len(txPrevList) != 1 {
-		return fmt.Errorf("not single FetchTxBySha(%v) - h %v: %v\n",
-			rd.txIn.PreviousOutPoint.Hash, rd.in.H, len(txPrevList))
+	blkPrev, err := src.BlockByHeight(prevHeight)
+	if err != nil {
+		return fmt.Errorf("prev - h %v: %v\n", rd.in.H, err)
 	}
-
-	blkPrev, err := db.FetchBlockBySha(txPrevList[0].BlkSha)
+	blkPrevSha, err := blkPrev.Sha()
 	if err != nil {
-		return fmt.Errorf("failed prev FetchBlockBySha(%v) - h %v: %v\n",
-			txPrevList[0].BlkSha, rd.in.H, err)
+		return err
 	}
 
-	rd.txPrev = txPrevList[0]
+	rd.txPrev = txPrev
 	rd.txPrevOutIndex = rd.txIn.PreviousOutPoint.Index
-	rd.txPrevOut = rd.txPrev.Tx.TxOut[rd.txPrevOutIndex]
+	rd.txPrevOut = rd.txPrev.TxOut[rd.txPrevOutIndex]
 	rd.blkPrev = blkPrev
+	rd.blkPrevSha = blkPrevSha
 
+	fingerprint(src, rd)
 	return nil
 }
 
-func printLine(rd *rData) {
-	fmt.Printf("%v\t%v\t%v",
-		rd.in.H,
-		rd.blkSha.String(),
-		rd.blk.MsgBlock().Header.Timestamp.Unix(),
-	)
-
-	fmt.Printf("\t%v\t%v\t%v", rd.in.Tx, rd.tx.Sha(), rd.in.TxIn)
-
-	fmt.Printf("\t%v\t%v\t%v",
-		rd.blkPrev.Height(),
-		rd.txPrev.BlkSha.String(),
-		rd.blkPrev.MsgBlock().Header.Timestamp.Unix(),
-	)
-
-	fmt.Printf("\t%v", rd.r)
-
-	if rd.address != "" {
-		fmt.Printf("\t%v", rd.address)
+// fingerprint sets the wallet of rd. Without a fee, or without a
+// signature to look at, fewer wallets can be told apart.
+func fingerprint(src rscan.Source, rd *rData) {
+	fee, err := rscan.TxFee(src, rd.tx.MsgTx())
+	if err != nil {
+		fee = -1
 	}
-
-	if rd.wif != nil {
-		fmt.Printf("\t%v", rd.wif.String())
+	f, err := rscan.Fingerprint(rd.tx.MsgTx(), rd.txInIndex, rd.in.Push, fee)
+	if err != nil {
+		rd.wallet = rscan.UnknownWallet
+		return
 	}
-
-	fmt.Print("\n")
+	rd.wallet = f.Wallet()
 }
 
 func main() {
 	var (
-		dataDir = flag.String("datadir", filepath.Join(btcutil.AppDataDir("btcd", false), "data"), "BTCD: Data directory")
-		dbType  = flag.String("dbtype", "leveldb", "BTCD: Database backend")
+		testnet = flag.Bool("testnet", false, "BTCD: Use the test network")
+		regtest = flag.Bool("regtest", false, "BTCD: Use the regression test network")
+
+		related  = flag.String("related", "", "search the signatures of this address or hex pubkey for related nonces")
+		maxDiff  = flag.Int64("maxdiff", 1<<20, "related: largest difference between nonces to try")
+		maxMul   = flag.Int64("maxmul", 1, "related: largest multiplier between nonces to try")
+		biasBits = flag.String("biasbits", "128,64,32,16", "related: numbers of zero nonce bits to try with the lattice attack")
+		jsonFile = flag.String("json", "blockchainr.json", "blockchainr output")
+		format   = flag.String("format", "tsv", "output format: tsv, csv or jsonl")
+		balances = flag.String("balances", "", "write the balance and outputs of the recovered keys to this file (walks the whole chain)")
 	)
+	var cfg rscan.SourceConfig
+	cfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	db, err := btcdbSetup(*dataDir, *dbType)
+	switch flag.Arg(0) {
+	case "tag":
+		tagCommand(flag.Args()[1:])
+		return
+	case "serve":
+		serveCommand(flag.Args()[1:])
+		return
+	}
+
+	activeNet, cfg.NetDir = rscan.NetParams(*testnet, *regtest)
+	cfg.Net = activeNet
+
+	src, err := rscan.OpenSource(&cfg)
 	if err != nil {
-		log.Println("btcdbSetup error:", err)
+		log.Println("OpenSource error:", err)
 		return
 	}
-	defer db.Close()
+	defer src.Close()
 
-	var jsonFile = flag.String("json", "blockchainr.json", "blockchainr output")
-	flag.Parse()
+	out, err := newRowWriter(*format, os.Stdout)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if *related != "" {
+		s := &relatedSearch{MaxDiff: *maxDiff, MaxMul: *maxMul}
+		for _, b := range strings.Split(*biasBits, ",") {
+			if b == "" {
+				continue
+			}
+			bits, err := strconv.Atoi(b)
+			if err != nil {
+				log.Println("invalid -biasbits:", err)
+				return
+			}
+			s.BiasBits = append(s.BiasBits, bits)
+		}
+		relatedCommand(src, *related, s, out)
+		return
+	}
 
 	blockchainrFile, err := ioutil.ReadFile(*jsonFile)
 	if err != nil {
@@ -160,83 +226,96 @@ func main() {
 		return
 	}
 
-	results := make(map[string][]*inData)
-	err = json.Unmarshal(blockchainrFile, &results)
+	var res results
+	err = json.Unmarshal(blockchainrFile, &res)
 	if err != nil {
 		log.Println("Unmarshal error:", err)
 		return
 	}
+	if res.Version != resultsVersion {
+		log.Println("Unsupported blockchainr.json version:", res.Version)
+		return
+	}
+	if res.Net != activeNet.Name {
+		log.Printf("blockchainr.json is for %v, not %v\n", res.Net, activeNet.Name)
+		return
+	}
 
-	fmt.Println("blkH\tblkSha\tblkTime\ttxIndex\ttxSha\ttxInIndex\tprevBlkH\tprevBlkSha\tprevBlkTime\tr\taddr\twif")
-
+	// Signatures are grouped by public key point and r, so that the
+	// compressed and uncompressed forms of a key end up together
 	targets := make(map[[2]string][]*rData)
 
-	for r, inDataList := range results {
+	var rds []*rData
+	for r, inDataList := range res.Duplicates {
 		for _, in := range inDataList {
 			rd := &rData{r: r, in: in}
+			rds = append(rds, rd)
 
-			if err := fetch(db, rd); err != nil {
+			if err := fetch(src, rd); err != nil {
 				log.Println("Skipping at fetch:", err)
-				printLine(rd)
+				rd.status, rd.reason = statusSkipped, fmt.Sprintf("fetch: %v", err)
 				continue
 			}
 
-			switch t := btcscript.GetScriptClass(rd.txPrevOut.PkScript); t {
-			case btcscript.PubKeyHashTy:
-				if err := processPubKeyHash(db, rd); err != nil {
-					log.Println("Skipping at opCheckSig:", err)
-					printLine(rd)
-					continue
-				}
-			default:
-				log.Println("Unsupported pkScript type:",
-					btcscript.ScriptClassToName[t], rd.in)
-				printLine(rd)
+			if err := verify(rd); err != nil {
+				log.Println("Skipping at verify:", err)
+				rd.status, rd.reason = statusSkipped, fmt.Sprintf("verify: %v", err)
 				continue
 			}
 
-			// TODO: group compressed and uncompressed together
-			key := [...]string{rd.address, rd.r}
+			point := pointKey(rd)
+			key := [...]string{point, rd.r}
 			targets[key] = append(targets[key], rd)
 		}
 	}
 
-	// Do the magic!
-	for _, target := range targets {
-		if len(target) < 2 {
-			// The r value was reused across different addresses
-			// TODO: also this information would be interesting to graph
-
-			for _, rd := range target {
-				printLine(rd)
-			}
+	for key, target := range targets {
+		targets[key] = dropMalleated(target)
+	}
 
+	// Do the magic!
+	b := newBreaker(targets)
+	b.run()
+	b.report()
+
+	// The WIFs of each recovered key, compressed and uncompressed
+	wifs := make(map[string][2]*btcutil.WIF)
+	for point, k := range b.keys {
+		wifC, err := btcutil.NewWIF(k.privKey, activeNet, true)
+		if err != nil {
+			log.Printf("NewWIF error: %v\n", err)
 			continue
 		}
-
-		a := target[0]
-		b := target[1]
-
-		log.Printf("[%v]\n", a.address)
-		log.Printf("Repeated r value: %v (%v times)\n", a.r, len(target))
-
-		privKey := recoverKey(a.signature, b.signature, a.hash, b.hash, a.pubKey)
-		if privKey == nil {
-			log.Print("recoverKey error\n\n")
+		wifU, err := btcutil.NewWIF(k.privKey, activeNet, false)
+		if err != nil {
+			log.Printf("NewWIF error: %v\n", err)
 			continue
 		}
+		wifs[point] = [...]*btcutil.WIF{wifC, wifU}
 
-		wif, err := btcutil.NewWIF(privKey, &btcnet.MainNetParams, a.compressed)
-		if err != nil {
-			log.Printf("NewWIF error: %v\n\n", err)
-			continue
+		rd := k.sigs[0]
+		log.Printf("%v %v %v %v\n", rd.address, rd.altAddress, wifC.String(), wifU.String())
+	}
+
+	if *balances != "" {
+		if err := reportBalances(src, b.keys, wifs, *balances); err != nil {
+			log.Println("failed to compute the balances:", err)
 		}
+	}
 
+	for key, target := range targets {
+		w, ok := wifs[key[0]]
 		for _, rd := range target {
-			rd.wif = wif
-			printLine(rd)
+			if ok && rd.compressed {
+				rd.wif, rd.altWif = w[0], w[1]
+			} else if ok {
+				rd.wif, rd.altWif = w[1], w[0]
+			}
+			rd.status, rd.reason = b.outcome(key)
 		}
+	}
 
-		log.Printf("%v\n\n", wif.String())
+	if err := writeRows(out, rds); err != nil {
+		log.Println("failed to write the output:", err)
 	}
 }
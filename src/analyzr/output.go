@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The values of the status column.
+const (
+	statusRecovered = "recovered" // the key is in the wif columns
+	statusFailed    = "failed"    // the signature was checked, but no key came out of it
+	statusSkipped   = "skipped"   // the signature couldn't be fetched or verified, or is a malleated copy
+)
+
+// columns is the schema of the analyzr output, the same in all the
+// formats. Every row has all of them, empty (null in JSON) when unknown.
+var columns = []string{
+	"blkH", "blkSha", "blkTime", "txIndex", "txSha", "txInIndex",
+	"prevBlkH", "prevBlkSha", "prevBlkTime",
+	"r", "addr", "wif", "altAddr", "altWif",
+	"status", "reason", "wallet",
+}
+
+// outputRow is a line of output, describing one signature.
+type outputRow struct {
+	BlkH      int64  `json:"blkH"`
+	BlkSha    string `json:"blkSha"`
+	BlkTime   *int64 `json:"blkTime"`
+	TxIndex   int    `json:"txIndex"`
+	TxSha     string `json:"txSha"`
+	TxInIndex int    `json:"txInIndex"`
+
+	PrevBlkH    *int64 `json:"prevBlkH"`
+	PrevBlkSha  string `json:"prevBlkSha"`
+	PrevBlkTime *int64 `json:"prevBlkTime"`
+
+	R       string `json:"r"`
+	Addr    string `json:"addr"`
+	Wif     string `json:"wif"`
+	AltAddr string `json:"altAddr"`
+	AltWif  string `json:"altWif"`
+
+	// Status is one of the status constants, and Reason says how the key
+	// was recovered, or why it wasn't
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+
+	// Wallet is the likely wallet family that made the signature
+	Wallet string `json:"wallet"`
+}
+
+func newOutputRow(rd *rData) *outputRow {
+	row := &outputRow{
+		BlkH:      rd.in.H,
+		TxIndex:   rd.in.Tx,
+		TxInIndex: rd.in.TxIn,
+		R:         rd.r,
+		Addr:      rd.address,
+		AltAddr:   rd.altAddress,
+		Status:    rd.status,
+		Reason:    rd.reason,
+		Wallet:    rd.wallet,
+	}
+	if rd.blk != nil {
+		t := rd.blk.MsgBlock().Header.Timestamp.Unix()
+		row.BlkSha, row.BlkTime = rd.blkSha.String(), &t
+		row.TxSha = rd.tx.Sha().String()
+	}
+	if rd.blkPrev != nil {
+		h, t := rd.blkPrev.Height(), rd.blkPrev.MsgBlock().Header.Timestamp.Unix()
+		row.PrevBlkH, row.PrevBlkSha, row.PrevBlkTime = &h, rd.blkPrevSha.String(), &t
+	}
+	if rd.wif != nil {
+		row.Wif, row.AltWif = rd.wif.String(), rd.altWif.String()
+	}
+	return row
+}
+
+// fields returns the values of row in the order of columns.
+func (row *outputRow) fields() []string {
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	return []string{
+		strconv.FormatInt(row.BlkH, 10), row.BlkSha, optional(row.BlkTime),
+		strconv.Itoa(row.TxIndex), row.TxSha, strconv.Itoa(row.TxInIndex),
+		optional(row.PrevBlkH), row.PrevBlkSha, optional(row.PrevBlkTime),
+		row.R, row.Addr, row.Wif, row.AltAddr, row.AltWif,
+		row.Status, row.Reason, row.Wallet,
+	}
+}
+
+// rowWriter writes the output in one of the -format formats.
+type rowWriter interface {
+	Write(row *outputRow) error
+	Flush() error
+}
+
+// newRowWriter returns a rowWriter for format, one of jsonl, csv or tsv.
+// The csv and tsv formats start with a header line.
+func newRowWriter(format string, w io.Writer) (rowWriter, error) {
+	switch format {
+	case "jsonl":
+		return jsonlWriter{json.NewEncoder(w)}, nil
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return sepWriter{cw}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w jsonlWriter) Write(row *outputRow) error { return w.enc.Encode(row) }
+func (w jsonlWriter) Flush() error               { return nil }
+
+type sepWriter struct {
+	w *csv.Writer
+}
+
+func (w sepWriter) Write(row *outputRow) error { return w.w.Write(row.fields()) }
+
+func (w sepWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// setField sets the column name of row from its text form.
+func (row *outputRow) setField(name, value string) error {
+	optional := func(v **int64) error {
+		if value == "" {
+			*v = nil
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		*v = &n
+		return err
+	}
+	var err error
+	switch name {
+	case "blkH":
+		row.BlkH, err = strconv.ParseInt(value, 10, 64)
+	case "blkSha":
+		row.BlkSha = value
+	case "blkTime":
+		err = optional(&row.BlkTime)
+	case "txIndex":
+		row.TxIndex, err = strconv.Atoi(value)
+	case "txSha":
+		row.TxSha = value
+	case "txInIndex":
+		row.TxInIndex, err = strconv.Atoi(value)
+	case "prevBlkH":
+		err = optional(&row.PrevBlkH)
+	case "prevBlkSha":
+		row.PrevBlkSha = value
+	case "prevBlkTime":
+		err = optional(&row.PrevBlkTime)
+	case "r":
+		row.R = value
+	case "addr":
+		row.Addr = value
+	case "wif":
+		row.Wif = value
+	case "altAddr":
+		row.AltAddr = value
+	case "altWif":
+		row.AltWif = value
+	case "status":
+		row.Status = value
+	case "reason":
+		row.Reason = value
+	case "wallet":
+		row.Wallet = value
+	}
+	if err != nil {
+		return fmt.Errorf("bad %v: %v", name, err)
+	}
+	return nil
+}
+
+// readRows reads back an output file, in the format of its extension:
+// .jsonl, .csv, or anything else for tsv. The columns missing from older
+// files are left empty.
+func readRows(filename string) ([]*outputRow, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []*outputRow
+	if filepath.Ext(filename) == ".jsonl" {
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			row := new(outputRow)
+			if err := dec.Decode(row); err == io.EOF {
+				return rows, nil
+			} else if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	if filepath.Ext(filename) != ".csv" {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		row := new(outputRow)
+		for i, value := range fields {
+			if i >= len(header) {
+				break
+			}
+			if err := row.setField(header[i], value); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// writeRows writes a row for each signature in rds, in chain order.
+func writeRows(w rowWriter, rds []*rData) error {
+	sort.Sort(byPosition(rds))
+	for _, rd := range rds {
+		if err := w.Write(newOutputRow(rd)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// byPosition sorts signatures in chain order.
+type byPosition []*rData
+
+func (s byPosition) Len() int      { return len(s) }
+func (s byPosition) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPosition) Less(i, j int) bool {
+	a, b := s[i].in, s[j].in
+	if a.H != b.H {
+		return a.H < b.H
+	}
+	if a.Tx != b.Tx {
+		return a.Tx < b.Tx
+	}
+	if a.TxIn != b.TxIn {
+		return a.TxIn < b.TxIn
+	}
+	return a.Push < b.Push
+}
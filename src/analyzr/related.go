@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/big"
+
+	"rscan"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+)
+
+// relatedSearch holds the parameters of the related nonces search.
+type relatedSearch struct {
+	// MaxDiff and MaxMul bound the relations k_j = a*k_i + b that are
+	// tried between each pair: 1 <= a <= MaxMul and |b| <= MaxDiff
+	MaxDiff int64
+	MaxMul  int64
+
+	// BiasBits are the numbers of known zero top (and bottom) bits of the
+	// nonces to try with the lattice attack
+	BiasBits []int
+}
+
+// findSignatures walks the chain and returns all the signatures on
+// P2PKH inputs by the key in target, either a P2PKH address or a hex
+// public key (in which case both its encodings are searched), in chain
+// order.
+func findSignatures(src rscan.Source, target string) ([]*rData, error) {
+	addr, err := btcutil.DecodeAddress(target, activeNet)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes [][]byte
+	switch a := addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		hashes = append(hashes, a.ScriptAddress())
+	case *btcutil.AddressPubKey:
+		a.SetFormat(btcutil.PKFCompressed)
+		hashes = append(hashes, a.AddressPubKeyHash().ScriptAddress())
+		a.SetFormat(btcutil.PKFUncompressed)
+		hashes = append(hashes, a.AddressPubKeyHash().ScriptAddress())
+	default:
+		return nil, fmt.Errorf("%v is not a P2PKH address or a pubkey", target)
+	}
+
+	_, maxHeigth, err := src.Tip()
+	if err != nil {
+		return nil, err
+	}
+
+	var sigs []*rData
+	for h := int64(0); h <= maxHeigth; h++ {
+		if h%10000 == 0 {
+			log.Printf("Searching for the signatures of %v: %v/%v\n", target, h, maxHeigth)
+		}
+
+		blk, err := src.BlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, tx := range blk.Transactions() {
+			for j, txIn := range tx.MsgTx().TxIn {
+				data, err := btcscript.PushedData(txIn.SignatureScript)
+				if err != nil || len(data) != 2 {
+					continue
+				}
+				pkHash := btcutil.Hash160(data[1])
+				match := false
+				for _, hash := range hashes {
+					match = match || bytes.Equal(pkHash, hash)
+				}
+				if !match {
+					continue
+				}
+
+				rd := &rData{in: &inData{H: h, Tx: i, TxIn: j}}
+				if err := fetch(src, rd); err != nil {
+					log.Println("Skipping at fetch:", err)
+					continue
+				}
+				if t := btcscript.GetScriptClass(rd.txPrevOut.PkScript); t != btcscript.PubKeyHashTy {
+					continue
+				}
+				if err := verify(rd); err != nil {
+					log.Println("Skipping at verify:", err)
+					continue
+				}
+				rd.r = fmt.Sprintf("%064x", rd.Signature.R)
+				sigs = append(sigs, rd)
+			}
+		}
+	}
+
+	return sigs, nil
+}
+
+// related runs all the related nonces attacks on the signatures of a
+// single key, and returns the private key and how it was found.
+func (s *relatedSearch) related(sigs []*rData) (*btcec.PrivateKey, string) {
+	for a := int64(1); a <= s.MaxMul; a++ {
+		for i := range sigs {
+			for j := range sigs {
+				// k_j = k_i + b and k_i = k_j - b are the same
+				if i == j || (a == 1 && j < i) {
+					continue
+				}
+				if k := rscan.RecoverAffine(sigs[i].Verified, sigs[j].Verified, a, s.MaxDiff); k != nil {
+					return k, fmt.Sprintf("k2 = %v*k1 + b between %v and %v",
+						a, sigName(sigs[i]), sigName(sigs[j]))
+				}
+
+				// k and N-k give the same R, and the signature
+				// might have been normalized
+				flipped := *sigs[j].Verified
+				flipped.Signature = &btcec.Signature{
+					R: flipped.Signature.R,
+					S: new(big.Int).Sub(btcec.S256().Params().N, flipped.Signature.S),
+				}
+				if k := rscan.RecoverAffine(sigs[i].Verified, &flipped, a, s.MaxDiff); k != nil {
+					return k, fmt.Sprintf("k2 = -%v*k1 + b between %v and %v",
+						a, sigName(sigs[i]), sigName(sigs[j]))
+				}
+			}
+		}
+	}
+
+	N := btcec.S256().Params().N
+	vs := make([]*rscan.Verified, len(sigs))
+	for i, rd := range sigs {
+		vs[i] = rd.Verified
+	}
+	for _, bits := range s.BiasBits {
+		if bits <= 0 || bits >= N.BitLen() {
+			continue
+		}
+
+		// A few more signatures than the information theoretic minimum
+		m := N.BitLen()/bits + 4
+		if m > len(sigs) {
+			m = len(sigs)
+		}
+		if m < 2 {
+			continue
+		}
+
+		if k := rscan.RecoverBiased(vs[:m], bits, false); k != nil {
+			return k, fmt.Sprintf("top %v bits of %v nonces are zero", bits, m)
+		}
+		if k := rscan.RecoverBiased(vs[:m], bits, true); k != nil {
+			return k, fmt.Sprintf("bottom %v bits of %v nonces are zero", bits, m)
+		}
+	}
+
+	return nil, ""
+}
+
+// relatedCommand implements "analyzr -related", printing the
+// signatures of the key and the recovered WIFs.
+func relatedCommand(src rscan.Source, target string, s *relatedSearch, out rowWriter) {
+	sigs, err := findSignatures(src, target)
+	if err != nil {
+		log.Println("failed to find the signatures:", err)
+		return
+	}
+	log.Printf("Found %v signatures by %v\n", len(sigs), target)
+	if len(sigs) < 2 {
+		return
+	}
+
+	privKey, how := s.related(sigs)
+	if privKey != nil {
+		wifC, err := btcutil.NewWIF(privKey, activeNet, true)
+		if err != nil {
+			log.Println("NewWIF error:", err)
+			return
+		}
+		wifU, err := btcutil.NewWIF(privKey, activeNet, false)
+		if err != nil {
+			log.Println("NewWIF error:", err)
+			return
+		}
+		log.Printf("[%v %v]\n", sigs[0].address, sigs[0].altAddress)
+		log.Printf("Related nonces: %v\n", how)
+		log.Printf("%v %v\n", wifC.String(), wifU.String())
+
+		for _, rd := range sigs {
+			if rd.compressed {
+				rd.wif, rd.altWif = wifC, wifU
+			} else {
+				rd.wif, rd.altWif = wifU, wifC
+			}
+			rd.status, rd.reason = statusRecovered, "related nonces: "+how
+		}
+	} else {
+		log.Println("No related nonces found")
+		for _, rd := range sigs {
+			rd.status, rd.reason = statusFailed, "no related nonces found"
+		}
+	}
+
+	if err := writeRows(out, sigs); err != nil {
+		log.Println("failed to write the output:", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"rscan"
+
+	"github.com/conformal/btcwire"
+)
+
+// The timeline page and d3, served by "analyzr serve".
+//
+//go:embed html
+var assets embed.FS
+
+var drilldownTemplate = template.Must(template.New("drilldown.html").Funcs(template.FuncMap{
+	"unixTime": func(t int64) string {
+		return time.Unix(t, 0).UTC().Format("2006-01-02 15:04")
+	},
+}).ParseFS(assets, "html/drilldown.html"))
+
+// dashboard serves the analyzr output, the tags and the transactions
+// they point to.
+type dashboard struct {
+	src  rscan.Source
+	rows []*outputRow
+	tags map[string]json.RawMessage
+}
+
+// rawTx is a transaction shown in a drill-down page.
+type rawTx struct {
+	TxID   string `json:"txid"`
+	Height int64  `json:"height"`
+	Hex    string `json:"hex"`
+	Error  string `json:"error,omitempty"`
+}
+
+// drilldown is the content of a per-R or per-address page.
+type drilldown struct {
+	Title string       `json:"title"`
+	Rows  []*outputRow `json:"rows"`
+	Txs   []*rawTx     `json:"txs"`
+	Tags  *addrTags    `json:"tags,omitempty"`
+}
+
+// serveCommand implements "analyzr serve", an HTTP dashboard over an
+// analyzr output and the tags.json made from it by "analyzr tag".
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	testnet := fs.Bool("testnet", false, "BTCD: Use the test network")
+	regtest := fs.Bool("regtest", false, "BTCD: Use the regression test network")
+	listen := fs.String("http", "localhost:8080", "address to listen on")
+	tagsFile := fs.String("tags", "tags.json", "tags from analyzr tag, optional")
+	var cfg rscan.SourceConfig
+	cfg.RegisterFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Fatal("usage: analyzr serve [-http addr] [-tags tags.json] analyzr.tsv")
+	}
+
+	rows, err := readRows(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read %v: %v", fs.Arg(0), err)
+	}
+	// The dashboard shows whether a key was recovered, not the key
+	for _, row := range rows {
+		row.Wif, row.AltWif = "", ""
+	}
+
+	tags := make(map[string]json.RawMessage)
+	buf, err := ioutil.ReadFile(*tagsFile)
+	if err == nil {
+		err = json.Unmarshal(buf, &tags)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("failed to read %v: %v", *tagsFile, err)
+	}
+
+	activeNet, cfg.NetDir = rscan.NetParams(*testnet, *regtest)
+	cfg.Net = activeNet
+	src, err := rscan.OpenSource(&cfg)
+	if err != nil {
+		log.Fatalln("OpenSource error:", err)
+	}
+	defer src.Close()
+
+	d := &dashboard{src: src, rows: rows, tags: tags}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.serveAsset)
+	mux.HandleFunc("/api/rows", d.serveRows)
+	mux.HandleFunc("/api/tags", d.serveTags)
+	mux.HandleFunc("/r/", d.serveDrilldown)
+	mux.HandleFunc("/addr/", d.serveDrilldown)
+	mux.HandleFunc("/api/r/", d.serveDrilldown)
+	mux.HandleFunc("/api/addr/", d.serveDrilldown)
+
+	log.Printf("Serving %v signatures on http://%v/\n", len(rows), *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+func (d *dashboard) serveAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[1:]
+	if name == "" {
+		name = "tx-over-time-address.html"
+	}
+	if name == "drilldown.html" {
+		http.NotFound(w, r)
+		return
+	}
+	buf, err := assets.ReadFile("html/" + name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(buf))
+}
+
+// filter returns the rows matching the addr, r, from and to parameters of
+// a query. from and to are dates, and empty parameters match everything.
+func (d *dashboard) filter(r *http.Request) ([]*outputRow, error) {
+	q := r.URL.Query()
+	addr, R := q.Get("addr"), strings.ToLower(q.Get("r"))
+	var from, to int64
+	if s := q.Get("from"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, err
+		}
+		from = t.Unix()
+	}
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, err
+		}
+		to = t.AddDate(0, 0, 1).Unix()
+	}
+
+	rows := []*outputRow{}
+	for _, row := range d.rows {
+		if addr != "" && row.Addr != addr && row.AltAddr != addr {
+			continue
+		}
+		if R != "" && row.R != R {
+			continue
+		}
+		if (from != 0 || to != 0) && row.BlkTime == nil {
+			continue
+		}
+		if from != 0 && *row.BlkTime < from || to != 0 && *row.BlkTime >= to {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (d *dashboard) serveRows(w http.ResponseWriter, r *http.Request) {
+	rows, err := d.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, rows)
+}
+
+func (d *dashboard) serveTags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, d.tags)
+}
+
+// serveDrilldown serves the page, or with the /api prefix the JSON, of
+// the signatures of a R value or an address and their transactions.
+func (d *dashboard) serveDrilldown(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	api := strings.HasPrefix(path, "/api/")
+	path = strings.TrimPrefix(path, "/api")
+
+	dd := &drilldown{Rows: []*outputRow{}, Txs: []*rawTx{}}
+	switch {
+	case strings.HasPrefix(path, "/r/"):
+		R := strings.ToLower(path[len("/r/"):])
+		dd.Title = "R " + R
+		for _, row := range d.rows {
+			if row.R == R {
+				dd.Rows = append(dd.Rows, row)
+			}
+		}
+	case strings.HasPrefix(path, "/addr/"):
+		addr := path[len("/addr/"):]
+		dd.Title = "Address " + addr
+		for _, row := range d.rows {
+			if row.Addr == addr || row.AltAddr == addr {
+				dd.Rows = append(dd.Rows, row)
+			}
+		}
+		if raw, ok := d.tags[addr]; ok {
+			dd.Tags = new(addrTags)
+			if err := json.Unmarshal(raw, dd.Tags); err != nil {
+				dd.Tags = nil
+			}
+		}
+	}
+	if len(dd.Rows) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, row := range dd.Rows {
+		if row.TxSha == "" || seen[row.TxSha] {
+			continue
+		}
+		seen[row.TxSha] = true
+		dd.Txs = append(dd.Txs, d.rawTx(row.TxSha))
+	}
+
+	if api {
+		writeJSON(w, dd)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := drilldownTemplate.Execute(w, dd); err != nil {
+		log.Println("template error:", err)
+	}
+}
+
+// rawTx fetches a transaction from the chain, with the error as text if
+// it fails.
+func (d *dashboard) rawTx(txid string) *rawTx {
+	tx := &rawTx{TxID: txid}
+	sha, err := btcwire.NewShaHashFromStr(txid)
+	if err != nil {
+		tx.Error = err.Error()
+		return tx
+	}
+	msg, height, err := d.src.TxBySha(sha)
+	if err != nil {
+		tx.Error = err.Error()
+		return tx
+	}
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		tx.Error = err.Error()
+		return tx
+	}
+	tx.Height, tx.Hex = height, hex.EncodeToString(buf.Bytes())
+	return tx
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("failed to write the response:", err)
+	}
+}
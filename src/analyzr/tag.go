@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"rscan"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// addrTags are the tags of an address whose key leaked, in tags.json.
+type addrTags struct {
+	// Whether a transaction of the address spends or creates a multisig
+	// output
+	InMultisig  bool `json:"in-multisig"`
+	OutMultisig bool `json:"out-multisig"`
+
+	// Balance is what the P2PKH and P2PK outputs still hold
+	Balance int64 `json:"balance"`
+
+	// OutAddr are the other addresses paid by the transactions spending
+	// from the address, in chain order, and LastOutTime the time of the
+	// last of those transactions, null if there are none as in tagger.py
+	OutAddr     []string `json:"out-addr"`
+	LastOutTime *int64   `json:"last-out-time"`
+
+	// AttackerName is the known actor among OutAddr, null if none, and
+	// AttackerTime the times it was paid
+	AttackerName *string `json:"attacker-name"`
+	AttackerTime []int64 `json:"attacker-time"`
+
+	// lastOut is the first address paid by the last transaction
+	// spending from the address
+	lastOut string
+}
+
+// txTags are the tags of a transaction, in tags.json.
+type txTags struct {
+	// RepeatedR is set if the transaction reuses a R value with the same
+	// key in two of its inputs
+	RepeatedR bool `json:"repeated-r"`
+}
+
+// keyOutpoint is a P2PKH or P2PK output of a tagged address.
+type keyOutpoint struct {
+	addr  string
+	value int64
+}
+
+// tagCommand implements "analyzr tag", which follows where the funds of
+// the addresses in an analyzr output went, and writes tags.json for the
+// html pages.
+func tagCommand(args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	testnet := fs.Bool("testnet", false, "BTCD: Use the test network")
+	regtest := fs.Bool("regtest", false, "BTCD: Use the regression test network")
+	knownFile := fs.String("known", "", "JSON file mapping the addresses of known actors to their names")
+	output := fs.String("o", "tags.json", "tags output file")
+	var cfg rscan.SourceConfig
+	cfg.RegisterFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Fatal("usage: analyzr tag [-known known.json] [-o tags.json] analyzr.tsv")
+	}
+
+	known := make(map[string]string)
+	if *knownFile != "" {
+		buf, err := ioutil.ReadFile(*knownFile)
+		if err != nil {
+			log.Fatalf("failed to read %v: %v", *knownFile, err)
+		}
+		if err := json.Unmarshal(buf, &known); err != nil {
+			log.Fatalf("failed to parse %v: %v", *knownFile, err)
+		}
+	}
+
+	rows, err := readRows(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read %v: %v", fs.Arg(0), err)
+	}
+
+	activeNet, cfg.NetDir = rscan.NetParams(*testnet, *regtest)
+	cfg.Net = activeNet
+	src, err := rscan.OpenSource(&cfg)
+	if err != nil {
+		log.Fatalln("OpenSource error:", err)
+	}
+	defer src.Close()
+
+	addrs := make(map[string]*addrTags)
+	for _, row := range rows {
+		for _, addr := range []string{row.Addr, row.AltAddr} {
+			if addr != "" && addrs[addr] == nil {
+				addrs[addr] = &addrTags{OutAddr: []string{}, AttackerTime: []int64{}}
+			}
+		}
+	}
+	if err := followFunds(src, addrs, known); err != nil {
+		log.Fatalln("failed to follow the funds:", err)
+	}
+
+	tags := make(map[string]interface{})
+	for addr, t := range addrs {
+		tags[addr] = t
+	}
+	for _, txSha := range repeatedR(rows) {
+		tags[txSha] = &txTags{RepeatedR: true}
+	}
+
+	buf, err := json.MarshalIndent(tags, "", "    ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, buf, 0644); err != nil {
+		log.Fatalf("failed to write %v: %v", *output, err)
+	}
+
+	reportDestinations(addrs, known)
+}
+
+// followFunds walks the chain for the transactions of the addresses, and
+// tags them.
+func followFunds(src rscan.Source, addrs map[string]*addrTags, known map[string]string) error {
+	outpoints := make(map[btcwire.OutPoint]keyOutpoint)
+	return walkChain(src, "the transactions of the tagged addresses", func(h, time int64, tx *btcutil.Tx) error {
+		involved := make(map[string]bool)
+		spenders := make(map[string]bool)
+		for _, txIn := range tx.MsgTx().TxIn {
+			if o, ok := outpoints[txIn.PreviousOutPoint]; ok {
+				addrs[o.addr].Balance -= o.value
+				involved[o.addr], spenders[o.addr] = true, true
+				delete(outpoints, txIn.PreviousOutPoint)
+			}
+		}
+
+		var paid []string
+		multisig := false
+		for i, txOut := range tx.MsgTx().TxOut {
+			class, outAddrs, _, _ := btcscript.ExtractPkScriptAddrs(txOut.PkScript, activeNet)
+			multisig = multisig || class == btcscript.MultiSigTy
+			for _, a := range outAddrs {
+				addr := a.EncodeAddress()
+				paid = append(paid, addr)
+				if addrs[addr] == nil {
+					continue
+				}
+				involved[addr] = true
+				if class == btcscript.PubKeyHashTy || class == btcscript.PubKeyTy {
+					addrs[addr].Balance += txOut.Value
+					outpoints[*btcwire.NewOutPoint(tx.Sha(), uint32(i))] = keyOutpoint{addr, txOut.Value}
+				}
+			}
+		}
+		if len(involved) == 0 {
+			return nil
+		}
+
+		inMultisig, err := spendsMultisig(src, tx)
+		if err != nil {
+			return err
+		}
+		for addr := range involved {
+			t := addrs[addr]
+			t.InMultisig = t.InMultisig || inMultisig
+			t.OutMultisig = t.OutMultisig || multisig
+		}
+
+		for addr := range spenders {
+			t := addrs[addr]
+			t.LastOutTime, t.lastOut = &time, ""
+			for _, out := range paid {
+				if out == addr {
+					continue
+				}
+				if t.lastOut == "" {
+					t.lastOut = out
+				}
+				t.OutAddr = append(t.OutAddr, out)
+
+				name, ok := known[out]
+				if !ok {
+					continue
+				}
+				if t.AttackerName != nil && *t.AttackerName != name {
+					log.Printf("%v paid both %v and %v\n", addr, *t.AttackerName, name)
+				} else {
+					t.AttackerName = &name
+				}
+				t.AttackerTime = append(t.AttackerTime, time)
+			}
+		}
+		return nil
+	})
+}
+
+// spendsMultisig reports whether any input of tx spends a multisig
+// output.
+func spendsMultisig(src rscan.Source, tx *btcutil.Tx) (bool, error) {
+	for _, txIn := range tx.MsgTx().TxIn {
+		prev := txIn.PreviousOutPoint
+		if prev.Index == btcwire.MaxPrevOutIndex {
+			// Coinbase
+			continue
+		}
+		txPrev, _, err := src.TxBySha(&prev.Hash)
+		if err != nil {
+			return false, err
+		}
+		if int(prev.Index) >= len(txPrev.TxOut) {
+			return false, fmt.Errorf("tx %v: no output %v", prev.Hash, prev.Index)
+		}
+		if btcscript.GetScriptClass(txPrev.TxOut[prev.Index].PkScript) == btcscript.MultiSigTy {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// repeatedR returns the transactions that use the same R value and
+// address in more than one signature.
+func repeatedR(rows []*outputRow) []string {
+	seen := make(map[[3]string]bool)
+	repeated := make(map[string]bool)
+	for _, row := range rows {
+		if row.Addr == "" {
+			continue
+		}
+		key := [...]string{row.TxSha, row.R, row.Addr}
+		if seen[key] {
+			repeated[row.TxSha] = true
+		}
+		seen[key] = true
+	}
+
+	var txs []string
+	for tx := range repeated {
+		txs = append(txs, tx)
+	}
+	sort.Strings(txs)
+	return txs
+}
+
+// destination is an address paid by the tagged addresses, or a known
+// actor, and how many tagged addresses paid it.
+type destination struct {
+	name  string
+	count int
+}
+
+type byCount []destination
+
+func (s byCount) Len() int      { return len(s) }
+func (s byCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCount) Less(i, j int) bool {
+	if s[i].count != s[j].count {
+		return s[i].count > s[j].count
+	}
+	return s[i].name < s[j].name
+}
+
+// reportDestinations logs where the funds of the tagged addresses went
+// most often, first by address and then grouping the known actors and
+// leaving out SatoshiDice: the first address paid by the last spending
+// transaction, and all the addresses paid.
+func reportDestinations(addrs map[string]*addrTags, known map[string]string) {
+	last := make(map[string]int)
+	all := make(map[string]int)
+	for _, t := range addrs {
+		if t.lastOut != "" {
+			last[t.lastOut]++
+		}
+		paid := make(map[string]bool)
+		for _, out := range t.OutAddr {
+			paid[out] = true
+		}
+		for out := range paid {
+			all[out]++
+		}
+	}
+
+	// In the order of tagger.py: last, all, then the same grouped
+	var grouped []map[string]int
+	for _, counts := range []map[string]int{last, all} {
+		g := make(map[string]int)
+		for addr, n := range counts {
+			if strings.HasPrefix(addr, "1dice") {
+				continue
+			}
+			if name, ok := known[addr]; ok {
+				addr = name
+			}
+			g[addr] += n
+		}
+		grouped = append(grouped, g)
+	}
+	for _, counts := range []map[string]int{last, all, grouped[0], grouped[1]} {
+		logDestinations(counts)
+	}
+}
+
+func logDestinations(counts map[string]int) {
+	var ds []destination
+	for name, n := range counts {
+		if n > 1 {
+			ds = append(ds, destination{name, n})
+		}
+	}
+	sort.Sort(byCount(ds))
+
+	log.Print("\n")
+	for _, d := range ds {
+		log.Println(d.name, d.count)
+	}
+}
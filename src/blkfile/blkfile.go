@@ -0,0 +1,286 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package blkfile is a read-only btcdb.Db backed directly by the block
+// files of Bitcoin Core, blk*.dat, or by a bootstrap.dat, so that no btcd
+// database has to be built first.
+//
+// The files are made of records, each a network magic, a little endian
+// length and a serialized block, in no particular order. When the
+// database is opened only the headers are read, and the main chain is the
+// one with the most work starting at the genesis block of the network.
+// Blocks are read from the files when requested.
+//
+// The transaction index, needed by the FetchTx methods, is built in memory
+// with a full pass over the chain the first time it is used.
+//
+// The driver is registered as "blkfile", and takes the path of a Bitcoin
+// Core blocks directory (or data directory) or of a bootstrap.dat, and the
+// *btcnet.Params of the network:
+//
+//	db, err := btcdb.OpenDB("blkfile", "/home/user/.bitcoin/blocks", &btcnet.MainNetParams)
+package blkfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btclog"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcwire"
+)
+
+// Errors that the database functions may return.
+var (
+	ErrDbClosed = errors.New("database is closed")
+	ErrReadOnly = errors.New("blkfile databases are read-only")
+	ErrNoBlocks = errors.New("no block files found")
+)
+
+var log = btclog.Disabled
+
+func init() {
+	driver := btcdb.DriverDB{DbType: "blkfile", CreateDB: CreateDB, OpenDB: OpenDB}
+	btcdb.AddDBDriver(driver)
+}
+
+// OpenDB opens the block files at the path args[0] for the network
+// args[1], a *btcnet.Params.
+func OpenDB(args ...interface{}) (btcdb.Db, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("blkfile.OpenDB takes a path and a *btcnet.Params")
+	}
+	path, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("blkfile.OpenDB: the first argument must be a path")
+	}
+	net, ok := args[1].(*btcnet.Params)
+	if !ok {
+		return nil, fmt.Errorf("blkfile.OpenDB: the second argument must be a *btcnet.Params")
+	}
+
+	log = btcdb.GetLog()
+	return Open(path, net)
+}
+
+// CreateDB always fails, since the block files are written by Bitcoin Core.
+func CreateDB(args ...interface{}) (btcdb.Db, error) {
+	return nil, ErrReadOnly
+}
+
+// blockLoc is the position of a block in the files.
+type blockLoc struct {
+	file   int
+	offset int64
+	size   uint32
+	header btcwire.BlockHeader
+
+	// work is the total work of the chain up to this block, nil if not
+	// computed yet, and orphan is set if the block doesn't connect to
+	// the genesis block
+	work   *big.Int
+	height int64
+	orphan bool
+}
+
+// Open reads the headers of the blocks at path, and orders them in a
+// chain. path can be a bootstrap.dat, a directory of blk*.dat files, or a
+// directory with such a blocks subdirectory.
+func Open(path string, net *btcnet.Params) (*Db, error) {
+	files, err := blockFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Db{
+		net:     net,
+		names:   files,
+		files:   make([]*os.File, len(files)),
+		blocks:  make(map[btcwire.ShaHash]*blockLoc),
+		heights: make(map[btcwire.ShaHash]int64),
+	}
+	for i, name := range files {
+		if err := db.readHeaders(i, name); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := db.buildChain(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Infof("blkfile: %v blocks in %v files, main chain height %v",
+		len(db.blocks), len(files), len(db.chain)-1)
+	return db, nil
+}
+
+func blockFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "blk[0-9]*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		files, err = filepath.Glob(filepath.Join(path, "blocks", "blk[0-9]*.dat"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, ErrNoBlocks
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readHeaders adds to db.blocks all the blocks in the file, seeking over
+// their transactions.
+func (db *Db) readHeaders(file int, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	db.files[file] = f
+
+	var offset int64
+	buf := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			log.Warnf("blkfile: %v: truncated record at %v", name, offset)
+			return nil
+		}
+
+		magic := btcwire.BitcoinNet(binary.LittleEndian.Uint32(buf))
+		if magic == 0 {
+			// Bitcoin Core preallocates the files with zeroes
+			return nil
+		}
+		if magic != db.net.Net {
+			return fmt.Errorf("%v: wrong network magic %v at %v", name, magic, offset)
+		}
+
+		size := binary.LittleEndian.Uint32(buf[4:])
+		loc := &blockLoc{file: file, offset: offset + 8, size: size}
+		if err := loc.header.Deserialize(f); err == io.EOF || err == io.ErrUnexpectedEOF {
+			log.Warnf("blkfile: %v: truncated record at %v", name, offset)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%v: bad block header at %v: %v", name, offset, err)
+		}
+
+		offset = loc.offset + int64(size)
+		if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+			return err
+		}
+
+		sha, err := loc.header.BlockSha()
+		if err != nil {
+			return err
+		}
+		if _, ok := db.blocks[sha]; !ok {
+			db.blocks[sha] = loc
+		}
+	}
+}
+
+// buildChain computes the total work of all the blocks, and sets db.chain
+// to the chain ending in the block with the most work.
+func (db *Db) buildChain() error {
+	genesis, ok := db.blocks[*db.net.GenesisHash]
+	if !ok {
+		return fmt.Errorf("the genesis block of %v is not in the files", db.net.Name)
+	}
+	genesis.work = btcchain.CalcWork(genesis.header.Bits)
+
+	var tip *blockLoc
+	var tipSha btcwire.ShaHash
+	var path []*blockLoc
+	for sha, loc := range db.blocks {
+		// Walk back to a known block, then forward computing the work
+		path = path[:0]
+		for l := loc; l.work == nil && !l.orphan; {
+			path = append(path, l)
+			parent, ok := db.blocks[l.header.PrevBlock]
+			if !ok {
+				l.orphan = true
+				break
+			}
+			l = parent
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			l := path[i]
+			parent := db.blocks[l.header.PrevBlock]
+			if parent == nil || parent.orphan {
+				l.orphan = true
+				continue
+			}
+			l.height = parent.height + 1
+			l.work = new(big.Int).Add(parent.work, btcchain.CalcWork(l.header.Bits))
+		}
+
+		if loc.orphan {
+			continue
+		}
+		if tip == nil || loc.work.Cmp(tip.work) > 0 ||
+			(loc.work.Cmp(tip.work) == 0 && db.before(loc, tip)) {
+			tip, tipSha = loc, sha
+		}
+	}
+
+	db.chain = make([]btcwire.ShaHash, tip.height+1)
+	for sha, loc := tipSha, tip; ; {
+		db.chain[loc.height] = sha
+		db.heights[sha] = loc.height
+		if loc.height == 0 {
+			break
+		}
+		sha = loc.header.PrevBlock
+		loc = db.blocks[sha]
+	}
+	return nil
+}
+
+// before reports whether a comes first in the files. Between two chains
+// with the same work, the one seen first wins.
+func (db *Db) before(a, b *blockLoc) bool {
+	if a.file != b.file {
+		return a.file < b.file
+	}
+	return a.offset < b.offset
+}
+
+// readBlock reads the serialized block at loc.
+func (db *Db) readBlock(loc *blockLoc) ([]byte, error) {
+	db.Lock()
+	f := db.files[loc.file]
+	closed := db.closed
+	db.Unlock()
+	if closed {
+		return nil, ErrDbClosed
+	}
+
+	buf := make([]byte, loc.size)
+	if _, err := f.ReadAt(buf, loc.offset); err != nil {
+		return nil, fmt.Errorf("%v: reading the block at %v: %v", db.names[loc.file], loc.offset, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blkfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+var testNet = &btcnet.RegressionNetParams
+
+// testBlock makes a block on top of prev, with a coinbase marked by tag
+// and the transactions txs.
+func testBlock(prev *btcwire.MsgBlock, tag byte, txs ...*btcwire.MsgTx) *btcwire.MsgBlock {
+	prevSha, _ := prev.BlockSha()
+	blk := btcwire.NewMsgBlock(btcwire.NewBlockHeader(&prevSha, &btcwire.ShaHash{}, prev.Header.Bits, uint32(tag)))
+	coinbase := btcwire.NewMsgTx()
+	coinbase.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0xffffffff), []byte{tag}))
+	coinbase.AddTxOut(btcwire.NewTxOut(50e8, []byte{btcscript.OP_TRUE}))
+	blk.AddTransaction(coinbase)
+	for _, tx := range txs {
+		blk.AddTransaction(tx)
+	}
+	return blk
+}
+
+func writeBlocks(t *testing.T, name string, blocks ...*btcwire.MsgBlock) {
+	var buf bytes.Buffer
+	for _, blk := range blocks {
+		var b bytes.Buffer
+		if err := blk.Serialize(&b); err != nil {
+			t.Fatal(err)
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(testNet.Net))
+		binary.Write(&buf, binary.LittleEndian, uint32(b.Len()))
+		buf.Write(b.Bytes())
+	}
+	// Preallocated space
+	buf.Write(make([]byte, 100))
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlkFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blkfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesis := testNet.GenesisBlock
+	a1 := testBlock(genesis, 1)
+	spend := btcwire.NewMsgTx()
+	a1Coinbase, _ := a1.Transactions[0].TxSha()
+	spend.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&a1Coinbase, 0), nil))
+	spend.AddTxOut(btcwire.NewTxOut(50e8, []byte{btcscript.OP_TRUE}))
+	a2 := testBlock(a1, 2, spend)
+	b1 := testBlock(genesis, 3)
+	orphan := testBlock(testBlock(b1, 4), 5)
+
+	// Out of order, across two files, with a fork and an orphan
+	writeBlocks(t, filepath.Join(dir, "blk00000.dat"), b1, a2, genesis)
+	writeBlocks(t, filepath.Join(dir, "blk00001.dat"), orphan, a1)
+
+	db, err := btcdb.OpenDB("blkfile", dir, testNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sha, height, err := db.NewestSha()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2Sha, _ := a2.BlockSha(); height != 2 || !sha.IsEqual(&a2Sha) {
+		t.Errorf("NewestSha() = %v, %v, want %v, 2", sha, height, a2Sha)
+	}
+
+	for h, want := range []*btcwire.MsgBlock{genesis, a1, a2} {
+		wantSha, _ := want.BlockSha()
+		sha, err := db.FetchBlockShaByHeight(int64(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sha.IsEqual(&wantSha) {
+			t.Errorf("FetchBlockShaByHeight(%v) = %v, want %v", h, sha, wantSha)
+		}
+		blk, err := db.FetchBlockBySha(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk.Height() != int64(h) || len(blk.Transactions()) != len(want.Transactions) {
+			t.Errorf("FetchBlockBySha(%v) returned the wrong block", sha)
+		}
+	}
+
+	b1Sha, _ := b1.BlockSha()
+	if ok, _ := db.ExistsSha(&b1Sha); ok {
+		t.Errorf("the side chain block is in the main chain")
+	}
+
+	replies, err := db.FetchTxBySha(&a1Coinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].Height != 1 || !replies[0].TxSpent[0] {
+		t.Errorf("FetchTxBySha returned the wrong transaction")
+	}
+	if r := db.FetchUnSpentTxByShaList([]*btcwire.ShaHash{&a1Coinbase}); r[0].Err != btcdb.ErrTxShaMissing {
+		t.Errorf("FetchUnSpentTxByShaList returned a spent transaction")
+	}
+	spendSha, _ := spend.TxSha()
+	if r := db.FetchUnSpentTxByShaList([]*btcwire.ShaHash{&spendSha}); r[0].Err != nil || r[0].Height != 2 {
+		t.Errorf("FetchUnSpentTxByShaList(%v) = %v", spendSha, r[0].Err)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blkfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// Db is a read-only btcdb.Db over block files. Only the blocks in the
+// main chain are visible.
+type Db struct {
+	sync.Mutex
+
+	net    *btcnet.Params
+	names  []string
+	files  []*os.File
+	closed bool
+
+	// blocks has all the blocks in the files, chain the hashes of the
+	// main chain by height, and heights the reverse
+	blocks  map[btcwire.ShaHash]*blockLoc
+	chain   []btcwire.ShaHash
+	heights map[btcwire.ShaHash]int64
+
+	// txns is built on first use by txIndex
+	txOnce sync.Once
+	txErr  error
+	txns   map[btcwire.ShaHash][]*txLoc
+}
+
+// txLoc is the position and the spent outputs of a transaction.
+type txLoc struct {
+	height int64
+	offset int
+	spent  []bool
+}
+
+// Close closes the block files.
+func (db *Db) Close() error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrDbClosed
+	}
+	db.closed = true
+	for _, f := range db.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// DropAfterBlockBySha fails, the database is read-only.
+func (db *Db) DropAfterBlockBySha(*btcwire.ShaHash) error {
+	return ErrReadOnly
+}
+
+// ExistsSha reports whether the block is in the main chain.
+func (db *Db) ExistsSha(sha *btcwire.ShaHash) (bool, error) {
+	_, ok := db.heights[*sha]
+	return ok, nil
+}
+
+// FetchBlockBySha reads a block of the main chain from the files.
+func (db *Db) FetchBlockBySha(sha *btcwire.ShaHash) (*btcutil.Block, error) {
+	height, ok := db.heights[*sha]
+	if !ok {
+		return nil, btcdb.ErrBlockShaMissing
+	}
+	buf, err := db.readBlock(db.blocks[*sha])
+	if err != nil {
+		return nil, err
+	}
+	blk, err := btcutil.NewBlockFromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("block %v: %v", sha, err)
+	}
+	blk.SetHeight(height)
+	return blk, nil
+}
+
+// FetchBlockHeightBySha returns the height of a block of the main chain.
+func (db *Db) FetchBlockHeightBySha(sha *btcwire.ShaHash) (int64, error) {
+	height, ok := db.heights[*sha]
+	if !ok {
+		return 0, btcdb.ErrBlockShaMissing
+	}
+	return height, nil
+}
+
+// FetchBlockHeaderBySha returns the header of a block of the main chain.
+func (db *Db) FetchBlockHeaderBySha(sha *btcwire.ShaHash) (*btcwire.BlockHeader, error) {
+	if _, ok := db.heights[*sha]; !ok {
+		return nil, btcdb.ErrBlockShaMissing
+	}
+	header := db.blocks[*sha].header
+	return &header, nil
+}
+
+// FetchBlockShaByHeight returns the hash of the block of the main chain
+// at height.
+func (db *Db) FetchBlockShaByHeight(height int64) (*btcwire.ShaHash, error) {
+	if height < 0 || height >= int64(len(db.chain)) {
+		return nil, fmt.Errorf("unable to fetch block height %d since "+
+			"it is not within the valid range (%d-%d)", height, 0,
+			len(db.chain)-1)
+	}
+	sha := db.chain[height]
+	return &sha, nil
+}
+
+// FetchHeightRange returns the hashes of the blocks in [startHeight,
+// endHeight), or until the tip if endHeight is btcdb.AllShas.
+func (db *Db) FetchHeightRange(startHeight, endHeight int64) ([]btcwire.ShaHash, error) {
+	if endHeight == btcdb.AllShas || endHeight > int64(len(db.chain)) {
+		endHeight = int64(len(db.chain))
+	}
+	if startHeight < 0 {
+		return nil, fmt.Errorf("start height of fetch range must not "+
+			"be less than zero - got %d", startHeight)
+	}
+	if endHeight < startHeight {
+		return []btcwire.ShaHash{}, nil
+	}
+
+	hashList := make([]btcwire.ShaHash, endHeight-startHeight)
+	copy(hashList, db.chain[startHeight:endHeight])
+	return hashList, nil
+}
+
+// txIndex builds db.txns, reading the whole main chain.
+func (db *Db) txIndex() error {
+	db.txOnce.Do(func() {
+		log.Infof("blkfile: building the transaction index")
+		db.txns = make(map[btcwire.ShaHash][]*txLoc)
+		for height := range db.chain {
+			blk, err := db.FetchBlockBySha(&db.chain[height])
+			if err != nil {
+				db.txErr = err
+				return
+			}
+			for i, tx := range blk.Transactions() {
+				if !btcchain.IsCoinBase(tx) {
+					for _, txIn := range tx.MsgTx().TxIn {
+						prev := &txIn.PreviousOutPoint
+						txns := db.txns[prev.Hash]
+						if len(txns) == 0 {
+							continue
+						}
+						spent := txns[len(txns)-1].spent
+						if int(prev.Index) < len(spent) {
+							spent[prev.Index] = true
+						}
+					}
+				}
+
+				db.txns[*tx.Sha()] = append(db.txns[*tx.Sha()], &txLoc{
+					height: int64(height),
+					offset: i,
+					spent:  make([]bool, len(tx.MsgTx().TxOut)),
+				})
+			}
+		}
+		log.Infof("blkfile: %v transactions indexed", len(db.txns))
+	})
+	return db.txErr
+}
+
+// reply builds the TxListReply for a transaction in the index.
+func (db *Db) reply(sha *btcwire.ShaHash, txD *txLoc) *btcdb.TxListReply {
+	reply := &btcdb.TxListReply{Sha: sha, Height: txD.height}
+	blkSha := db.chain[txD.height]
+	blk, err := db.FetchBlockBySha(&blkSha)
+	if err != nil {
+		reply.Err = err
+		return reply
+	}
+	reply.Tx = blk.MsgBlock().Transactions[txD.offset]
+	reply.BlkSha = &blkSha
+	reply.TxSpent = make([]bool, len(txD.spent))
+	copy(reply.TxSpent, txD.spent)
+	return reply
+}
+
+func fullySpent(txD *txLoc) bool {
+	for _, spent := range txD.spent {
+		if !spent {
+			return false
+		}
+	}
+	return true
+}
+
+// ExistsTxSha reports whether the transaction is in the main chain and not
+// fully spent.
+func (db *Db) ExistsTxSha(sha *btcwire.ShaHash) (bool, error) {
+	if err := db.txIndex(); err != nil {
+		return false, err
+	}
+	if txns, ok := db.txns[*sha]; ok {
+		return !fullySpent(txns[len(txns)-1]), nil
+	}
+	return false, nil
+}
+
+// FetchTxBySha returns all the versions of a transaction.
+func (db *Db) FetchTxBySha(txsha *btcwire.ShaHash) ([]*btcdb.TxListReply, error) {
+	if err := db.txIndex(); err != nil {
+		return nil, err
+	}
+	txns, ok := db.txns[*txsha]
+	if !ok {
+		return nil, btcdb.ErrTxShaMissing
+	}
+
+	sha := *txsha
+	replies := make([]*btcdb.TxListReply, len(txns))
+	for i, txD := range txns {
+		replies[i] = db.reply(&sha, txD)
+		if replies[i].Err != nil {
+			return nil, replies[i].Err
+		}
+	}
+	return replies, nil
+}
+
+func (db *Db) fetchTxByShaList(txShaList []*btcwire.ShaHash, includeSpent bool) []*btcdb.TxListReply {
+	replies := make([]*btcdb.TxListReply, len(txShaList))
+	err := db.txIndex()
+	for i, sha := range txShaList {
+		replies[i] = &btcdb.TxListReply{Sha: sha, Err: btcdb.ErrTxShaMissing}
+		if err != nil {
+			replies[i].Err = err
+			continue
+		}
+		txns, ok := db.txns[*sha]
+		if !ok {
+			continue
+		}
+		txD := txns[len(txns)-1]
+		if !includeSpent && fullySpent(txD) {
+			continue
+		}
+		replies[i] = db.reply(sha, txD)
+	}
+	return replies
+}
+
+// FetchTxByShaList returns the most recent version of the transactions.
+func (db *Db) FetchTxByShaList(txShaList []*btcwire.ShaHash) []*btcdb.TxListReply {
+	return db.fetchTxByShaList(txShaList, true)
+}
+
+// FetchUnSpentTxByShaList is like FetchTxByShaList, but returns
+// btcdb.ErrTxShaMissing for the fully spent transactions.
+func (db *Db) FetchUnSpentTxByShaList(txShaList []*btcwire.ShaHash) []*btcdb.TxListReply {
+	return db.fetchTxByShaList(txShaList, false)
+}
+
+// InsertBlock fails, the database is read-only.
+func (db *Db) InsertBlock(*btcutil.Block) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+// NewestSha returns the tip of the main chain.
+func (db *Db) NewestSha() (*btcwire.ShaHash, int64, error) {
+	height := int64(len(db.chain) - 1)
+	sha := db.chain[height]
+	return &sha, height, nil
+}
+
+// RollbackClose is the same as Close, there are no changes to discard.
+func (db *Db) RollbackClose() error {
+	return db.Close()
+}
+
+// Sync does nothing.
+func (db *Db) Sync() error {
+	return nil
+}
@@ -0,0 +1,557 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"rscan"
+
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btclog"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/btcws"
+	"github.com/conformal/websocket"
+)
+
+const (
+	// followDepth is how many blocks are remembered to be undone if btcd
+	// disconnects them, and how long unconfirmed transactions are kept
+	followDepth = 144
+
+	reconnectDelay = 10 * time.Second
+)
+
+// alert is a reuse of an R value seen while following, written as a JSON
+// line to the alerts file.
+type alert struct {
+	Time time.Time `json:"time"`
+
+	// R is in hex, as in blockchainr.json and for -lookup
+	R string `json:"r"`
+
+	// The new signature. Height is -1 if it's from an unconfirmed
+	// transaction
+	TxID   string `json:"txid"`
+	TxIn   int    `json:"txIn"`
+	Data   int    `json:"data"`
+	Height int64  `json:"height"`
+
+	// Previous are the uses of R in the index, and PreviousMempool the
+	// unconfirmed transactions that used it
+	Previous        []*rscan.Signature `json:"previous"`
+	PreviousMempool []string           `json:"previousMempool,omitempty"`
+}
+
+// followedBlock is a block indexed while following, kept to undo it.
+type followedBlock struct {
+	sha    *btcwire.ShaHash
+	height int64
+	sigs   []*rscan.Signature
+}
+
+// mempoolTx is an unconfirmed transaction and the R values it used.
+type mempoolTx struct {
+	height int64
+	rs     []string
+}
+
+// follower keeps the index up to date with the blocks announced by btcd
+// over its websocket, and alerts about every R value reused by a new
+// block or unconfirmed transaction.
+type follower struct {
+	log       btclog.Logger
+	cfg       *rscan.SourceConfig
+	src       rscan.Source
+	idx       *rscan.Index
+	state     *scanState
+	stateFile string
+	alerts    *json.Encoder
+	malleable bool
+
+	// res is the content of blockchainr.json, kept up to date with the
+	// new occurrences only, and verified the cache of markCopies
+	res      *results
+	verified map[string]*rscan.Verified
+
+	recent     []*followedBlock
+	mempool    map[string][]string
+	mempoolTxs map[string]*mempoolTx
+}
+
+// followChain runs until interrupted by a signal, indexing the new blocks
+// as btcd connects them, and updating res, the results of the scan. The
+// blocks are fetched over RPC whatever the -dbtype, since btcd keeps its
+// database locked while running.
+func followChain(log btclog.Logger, cfg *rscan.SourceConfig, idx *rscan.Index,
+	state *scanState, res *results, stateFile, alertsFile string, malleable bool, signals chan os.Signal) {
+
+	src, err := rscan.NewRPCSource(cfg.RPCServer, cfg.RPCUser, cfg.RPCPass, cfg.RPCCert, cfg.NoTLS)
+	if err != nil {
+		log.Warnf("failed to setup the RPC client: %v", err)
+		return
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(alertsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Warnf("failed to open %v: %v", alertsFile, err)
+		return
+	}
+	defer f.Close()
+
+	fl := &follower{
+		log:        log,
+		cfg:        cfg,
+		src:        src,
+		idx:        idx,
+		state:      state,
+		stateFile:  stateFile,
+		alerts:     json.NewEncoder(f),
+		malleable:  malleable,
+		res:        res,
+		verified:   make(map[string]*rscan.Verified),
+		mempool:    make(map[string][]string),
+		mempoolTxs: make(map[string]*mempoolTx),
+	}
+
+	for {
+		conn, err := fl.dial()
+		if err == nil {
+			log.Infof("following %v", cfg.RPCServer)
+			if stop := fl.session(conn, signals); stop {
+				return
+			}
+		} else {
+			log.Warnf("failed to connect to %v: %v", cfg.RPCServer, err)
+		}
+
+		log.Infof("reconnecting in %v", reconnectDelay)
+		select {
+		case s := <-signals:
+			if s == syscall.SIGINT || s == syscall.SIGTERM {
+				return
+			}
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// dial connects to the btcd websocket and subscribes to the block and
+// verbose transaction notifications.
+func (fl *follower) dial() (*websocket.Conn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	scheme := "ws"
+	if !fl.cfg.NoTLS {
+		pem, err := ioutil.ReadFile(fl.cfg.RPCCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", fl.cfg.RPCCert)
+		}
+		dialer.TLSClientConfig = &tls.Config{RootCAs: pool}
+		scheme = "wss"
+	}
+
+	header := make(http.Header)
+	login := fl.cfg.RPCUser + ":" + fl.cfg.RPCPass
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(login)))
+	conn, _, err := dialer.Dial(scheme+"://"+fl.cfg.RPCServer+"/ws", header)
+	if err != nil {
+		return nil, err
+	}
+
+	txCmd, err := btcws.NewNotifyNewTransactionsCmd(2, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, cmd := range []btcjson.Cmd{btcws.NewNotifyBlocksCmd(1), txCmd} {
+		msg, err := cmd.MarshalJSON()
+		if err == nil {
+			err = conn.WriteMessage(websocket.TextMessage, msg)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%v: %v", cmd.Method(), err)
+		}
+	}
+	return conn, nil
+}
+
+// session handles the notifications from conn until the connection drops
+// or a stop signal arrives, in which case stop is true.
+func (fl *follower) session(conn *websocket.Conn, signals chan os.Signal) (stop bool) {
+	msgs := make(chan []byte)
+	errc := make(chan error, 1)
+	quit := make(chan struct{})
+	defer conn.Close()
+	defer close(quit)
+
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	// Catch up with the blocks connected and disconnected since the scan
+	// or the last connection
+	var err error
+	switch fork := fl.state.forkPoint(fl.src); {
+	case fork < 0:
+		fl.log.Warnf("the chain forked more than %v blocks ago, indexing again from height %v",
+			branchDepth, fl.state.Start)
+		err = fl.undo(fl.state.Start)
+	case fork <= fl.state.TipHeight:
+		fl.log.Warnf("the chain moved off block %v at height %v", fl.state.Tip, fl.state.TipHeight)
+		err = fl.undo(fork)
+	}
+	var tip int64
+	if err == nil {
+		_, tip, err = fl.src.Tip()
+	}
+	if err == nil {
+		err = fl.catchUp(tip)
+	}
+	if err != nil {
+		fl.log.Warnf("catching up failed: %v", err)
+		return false
+	}
+
+	for {
+		select {
+		case msg := <-msgs:
+			if err := fl.handle(msg); err != nil {
+				fl.log.Warnf("%v", err)
+				return false
+			}
+		case err := <-errc:
+			fl.log.Warnf("connection lost: %v", err)
+			return false
+		case s := <-signals:
+			fl.log.Infof("signal %v - indexed up to block %v, %v unconfirmed transactions",
+				s, fl.state.Height-1, len(fl.mempoolTxs))
+			if s == syscall.SIGINT || s == syscall.SIGTERM {
+				return true
+			}
+		}
+	}
+}
+
+func (fl *follower) handle(msg []byte) error {
+	cmd, err := btcjson.ParseMarshaledCmd(msg)
+	if err != nil {
+		// Not a notification, but the reply to a notify command
+		var reply btcjson.Reply
+		if json.Unmarshal(msg, &reply) == nil && reply.Error != nil {
+			return fmt.Errorf("subscription failed: %v", reply.Error)
+		}
+		return nil
+	}
+
+	switch n := cmd.(type) {
+	case *btcws.BlockConnectedNtfn:
+		return fl.catchUp(int64(n.Height))
+	case *btcws.BlockDisconnectedNtfn:
+		return fl.disconnect(n.Hash, int64(n.Height))
+	case *btcws.TxAcceptedVerboseNtfn:
+		fl.accept(n.RawTx)
+	}
+	return nil
+}
+
+// catchUp indexes all the blocks up to tip.
+func (fl *follower) catchUp(tip int64) error {
+	for h := fl.state.Height; h <= tip; h++ {
+		if err := fl.connect(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connect adds the block at height h to the index, alerting about the R
+// values already in use.
+func (fl *follower) connect(h int64) error {
+	blk, err := fl.src.BlockByHeight(h)
+	if err != nil {
+		return err
+	}
+	sigs, _ := rscan.BlockSignatures(blk)
+
+	batch := rscan.NewBatch()
+	repeated := make(map[string]*big.Int)
+	inBlock := make(map[string][]*rscan.Signature)
+	for _, rd := range sigs {
+		r := hex.EncodeToString(rscan.RPrefix(rd.Sig.R))
+		tx, _ := blk.Tx(rd.Tx)
+		txid := tx.Sha().String()
+
+		previous := inBlock[r]
+		if has, err := fl.idx.Has(rd.Sig.R); err != nil {
+			return fmt.Errorf("index lookup failed: %v", err)
+		} else if has {
+			rds, err := fl.idx.Lookup(rd.Sig.R)
+			if err != nil {
+				return fmt.Errorf("index lookup failed: %v", err)
+			}
+			previous = append(rds, previous...)
+		}
+		var previousMempool []string
+		for _, id := range fl.mempool[r] {
+			if id != txid {
+				previousMempool = append(previousMempool, id)
+			}
+		}
+
+		if len(previous) != 0 {
+			repeated[rd.Sig.R.String()] = rd.Sig.R
+		}
+		if len(previous) != 0 || len(previousMempool) != 0 {
+			fl.alert(&alert{
+				R: r, TxID: txid, TxIn: rd.TxIn, Data: rd.Data, Height: h,
+				Previous: previous, PreviousMempool: previousMempool,
+			})
+		}
+
+		inBlock[r] = append(inBlock[r], rd)
+		batch.Add(rd)
+	}
+	if err := fl.idx.Write(batch); err != nil {
+		return fmt.Errorf("index write failed: %v", err)
+	}
+
+	// Only the new uses are fetched and fingerprinted, the others are
+	// already in the results
+	blocks := map[int64]*btcutil.Block{h: blk}
+	fees := make(map[string]int64)
+	for r, R := range repeated {
+		rds, err := fl.idx.Lookup(R)
+		if err != nil {
+			return fmt.Errorf("index lookup failed: %v", err)
+		}
+		fl.state.Matches[r] = rds
+
+		key, err := addOccurrences(fl.src, fl.res, R, rds, blocks, fees)
+		if err != nil {
+			return fmt.Errorf("failed to build the results: %v", err)
+		}
+		if fl.malleable {
+			if err := markCopies(fl.src, fl.res.Duplicates[key], fl.verified); err != nil {
+				return fmt.Errorf("failed to check for malleated signatures: %v", err)
+			}
+		}
+	}
+	sha, _ := blk.Sha()
+	fl.state.Height = h + 1
+	fl.state.extendBranch(h, sha.String())
+	fl.res.End, fl.res.Tip = fl.state.Height, fl.state.Tip
+
+	fl.recent = append(fl.recent, &followedBlock{sha: sha, height: h, sigs: sigs})
+	if len(fl.recent) > followDepth {
+		fl.recent = fl.recent[1:]
+	}
+	for _, tx := range blk.Transactions() {
+		fl.forget(tx.Sha().String())
+	}
+	for txid, tx := range fl.mempoolTxs {
+		if tx.height < h-followDepth {
+			fl.forget(txid)
+		}
+	}
+
+	fl.log.Infof("indexed block %v at height %v - %v signatures, %v reused",
+		sha, h, len(sigs), len(repeated))
+	return fl.save(len(repeated) != 0)
+}
+
+// disconnect undoes the block at height, which btcd disconnected.
+func (fl *follower) disconnect(hash string, height int64) error {
+	if height >= fl.state.Height {
+		return nil
+	}
+	if height != fl.state.TipHeight || hash != fl.state.Tip {
+		fl.log.Warnf("block %v at height %v was disconnected, but the last block indexed is %v at height %v",
+			hash, height, fl.state.Tip, fl.state.TipHeight)
+	}
+	return fl.undo(height)
+}
+
+// undo removes the blocks from height up from the index and the state.
+// The blocks indexed by this run are removed one by one, the others with
+// a pass over the whole index.
+func (fl *follower) undo(height int64) error {
+	batch := rscan.NewBatch()
+	values := make(map[string]*big.Int)
+	lowest := fl.state.Height
+	for len(fl.recent) > 0 && fl.recent[len(fl.recent)-1].height >= height {
+		blk := fl.recent[len(fl.recent)-1]
+		for _, rd := range blk.sigs {
+			batch.Delete(rd)
+			values[rd.Sig.R.String()] = rd.Sig.R
+		}
+		lowest = blk.height
+		fl.recent = fl.recent[:len(fl.recent)-1]
+	}
+	if err := fl.idx.Write(batch); err != nil {
+		return fmt.Errorf("index write failed: %v", err)
+	}
+	if lowest > height {
+		fl.log.Warnf("blocks from height %v were not indexed by this run, removing them with a full pass over the index",
+			height)
+		if _, err := fl.idx.DeleteFrom(height); err != nil {
+			return fmt.Errorf("index write failed: %v", err)
+		}
+	}
+
+	fl.state.rewind(height)
+	rewindResults(fl.res, height)
+	fl.res.Tip = fl.state.Tip
+	fl.verified = make(map[string]*rscan.Verified)
+	for r, R := range values {
+		if _, ok := fl.state.Matches[r]; !ok {
+			continue
+		}
+		rds, err := fl.idx.Lookup(R)
+		if err != nil {
+			return fmt.Errorf("index lookup failed: %v", err)
+		}
+		if len(rds) > 1 {
+			fl.state.Matches[r] = rds
+		} else {
+			delete(fl.state.Matches, r)
+		}
+	}
+
+	fl.log.Infof("undid the blocks from height %v", height)
+	return fl.save(true)
+}
+
+// accept checks the signatures of an unconfirmed transaction against the
+// index and the other unconfirmed transactions.
+func (fl *follower) accept(raw *btcjson.TxRawResult) {
+	if raw == nil {
+		return
+	}
+	buf, err := hex.DecodeString(raw.Hex)
+	if err != nil {
+		fl.log.Warnf("bad transaction %v: %v", raw.Txid, err)
+		return
+	}
+	tx, err := btcutil.NewTxFromBytes(buf)
+	if err != nil {
+		fl.log.Warnf("bad transaction %v: %v", raw.Txid, err)
+		return
+	}
+	txid := tx.Sha().String()
+	if _, ok := fl.mempoolTxs[txid]; ok {
+		return
+	}
+
+	// Look all the values up before recording the transaction, so that
+	// if the index fails it's checked again when seen next
+	var sigs []*rscan.Signature
+	var previous [][]*rscan.Signature
+	for i, txIn := range tx.MsgTx().TxIn {
+		inSigs, _ := rscan.SigScriptSignatures(txIn.SignatureScript)
+		for _, rd := range inSigs {
+			rds, err := fl.idx.Lookup(rd.Sig.R)
+			if err != nil {
+				fl.log.Warnf("index lookup failed for transaction %v: %v", txid, err)
+				return
+			}
+			rd.TxIn = i
+			sigs = append(sigs, rd)
+			previous = append(previous, rds)
+		}
+	}
+
+	mtx := &mempoolTx{height: fl.state.Height}
+	fl.mempoolTxs[txid] = mtx
+	for i, rd := range sigs {
+		r := hex.EncodeToString(rscan.RPrefix(rd.Sig.R))
+		if len(previous[i]) != 0 || len(fl.mempool[r]) != 0 {
+			fl.alert(&alert{
+				R: r, TxID: txid, TxIn: rd.TxIn, Data: rd.Data, Height: -1,
+				Previous: previous[i], PreviousMempool: fl.mempool[r],
+			})
+		}
+		fl.mempool[r] = append(fl.mempool[r], txid)
+		mtx.rs = append(mtx.rs, r)
+	}
+}
+
+// forget drops an unconfirmed transaction, once mined or expired.
+func (fl *follower) forget(txid string) {
+	tx, ok := fl.mempoolTxs[txid]
+	if !ok {
+		return
+	}
+	for _, r := range tx.rs {
+		ids := fl.mempool[r][:0]
+		for _, id := range fl.mempool[r] {
+			if id != txid {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			delete(fl.mempool, r)
+		} else {
+			fl.mempool[r] = ids
+		}
+	}
+	delete(fl.mempoolTxs, txid)
+}
+
+func (fl *follower) alert(a *alert) {
+	a.Time = time.Now()
+	where := fmt.Sprintf("block %v", a.Height)
+	if a.Height < 0 {
+		where = "the mempool"
+	}
+	fl.log.Warnf("R reuse in %v: %v input %v, %v previous uses, %v unconfirmed",
+		where, a.TxID, a.TxIn, len(a.Previous), len(a.PreviousMempool))
+	if err := fl.alerts.Encode(a); err != nil {
+		fl.log.Warnf("failed to write the alert: %v", err)
+	}
+}
+
+// save checkpoints the state, and rewrites blockchainr.json if the
+// matches changed.
+func (fl *follower) save(changed bool) error {
+	if err := saveState(fl.stateFile, fl.state); err != nil {
+		return fmt.Errorf("failed to save %v: %v", fl.stateFile, err)
+	}
+	if changed {
+		labelClusters(fl.res)
+		if err := writeResults("blockchainr.json", fl.res); err != nil {
+			fl.log.Warnf("failed to write the results: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/big"
+
+	"rscan"
+)
+
+// searchIndex finds the repeated R values with a single pass over the new
+// blocks, adding every signature to the index.
+func searchIndex(state *scanState, idx *rscan.Index, w *walker) {
+	var writeErr error
+	batch := rscan.NewBatch()
+	repeated := make(map[string]*big.Int)
+
+	flush := func() {
+		if err := idx.Write(batch); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	h := w.walk(1, state.Height, w.end, func(rd *rscan.Signature) bool {
+		if writeErr != nil {
+			return false
+		}
+
+		match := batch.Has(rd.Sig.R)
+		if !match {
+			has, err := idx.Has(rd.Sig.R)
+			if err != nil {
+				w.log.Warnf("index lookup failed: %v", err)
+				writeErr = err
+				w.interrupt()
+				return false
+			}
+			match = has
+		}
+		if match {
+			repeated[rd.Sig.R.String()] = rd.Sig.R
+		}
+
+		batch.Add(rd)
+		if batch.Len() >= rscan.IndexBatchSize {
+			flush()
+			if writeErr != nil {
+				w.log.Warnf("index write failed: %v", writeErr)
+				w.interrupt()
+			}
+		}
+
+		return match
+	})
+	flush()
+	if writeErr != nil {
+		w.log.Warnf("index write failed, not saving progress: %v", writeErr)
+		return
+	}
+
+	// Unlike the bloom search, an interrupted scan can be checkpointed:
+	// all the blocks below h are in the index.
+	for r, R := range repeated {
+		rds, err := idx.Lookup(R)
+		if err != nil {
+			w.log.Warnf("index lookup failed, not saving progress: %v", err)
+			return
+		}
+		state.Matches[r] = rds
+	}
+	state.Height = h
+}
@@ -5,27 +5,27 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"runtime"
 	"runtime/pprof"
-	"sync"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/bitly/dablooms/godablooms"
+	"bloom"
+	"rscan"
 
-	"github.com/conformal/btcchain"
 	"github.com/conformal/btcdb"
-	_ "github.com/conformal/btcdb/ldb"
-	"github.com/conformal/btcec"
 	"github.com/conformal/btclog"
-	"github.com/conformal/btcscript"
-	"github.com/conformal/btcutil"
+	"github.com/conformal/btcnet"
 )
 
 type stringSet map[string]struct{}
@@ -45,215 +45,208 @@ const (
 	bloomRate = 0.005
 )
 
-func btcdbSetup(dataDir, dbType string) (log btclog.Logger, db btcdb.Db, cleanup func()) {
+func sourceSetup(cfg *rscan.SourceConfig) (log btclog.Logger, src rscan.Source, cleanup func()) {
 	// Setup logging
 	backendLogger := btclog.NewDefaultBackendLogger()
 	log = btclog.NewSubsystemLogger(backendLogger, "")
 	btcdb.UseLogger(log)
 
-	// Setup database access
-	blockDbNamePrefix := "blocks"
-	dbName := blockDbNamePrefix + "_" + dbType
-	if dbType == "sqlite" {
-		dbName = dbName + ".db"
-	}
-	dbPath := filepath.Join(dataDir, "mainnet", dbName)
-
-	log.Infof("loading db %v", dbType)
-	db, err := btcdb.OpenDB(dbType, dbPath)
+	// Setup the block source
+	log.Infof("loading %v", cfg.Type)
+	src, err := rscan.OpenSource(cfg)
 	if err != nil {
-		log.Warnf("db open failed: %v", err)
+		log.Warnf("open failed: %v", err)
 		return
 	}
-	log.Infof("db load complete")
+	log.Infof("load complete")
 
 	cleanup = func() {
-		db.Close()
+		src.Close()
 		backendLogger.Flush()
 	}
 
 	return
 }
 
-type rData struct {
-	sig  *btcec.Signature
-	H    int64
-	Tx   int
-	TxIn int
-	Data int
-}
+// walker runs the scan steps over the chain, handling the progress
+// logging and the signals.
+type walker struct {
+	log       btclog.Logger
+	src       rscan.Source
+	maxHeigth int64
+	workers   int
 
-func getSignatures(maxHeigth int64, log btclog.Logger, db btcdb.Db) chan *rData {
-	heigthChan := make(chan int64)
-	blockChan := make(chan *btcutil.Block)
-	sigChan := make(chan *rData)
+	// The range of blocks to scan, [start, end)
+	start, end int64
 
-	go func() {
-		for h := int64(0); h < maxHeigth; h++ {
-			heigthChan <- h
-		}
+	signalChan  chan os.Signal
+	stop        chan struct{}
+	interrupted bool
+}
 
-		close(heigthChan)
-	}()
-
-	var blockWg sync.WaitGroup
-	for i := 0; i <= 10; i++ {
-		blockWg.Add(1)
-		go func() {
-			for h := range heigthChan {
-				sha, err := db.FetchBlockShaByHeight(h)
-				if err != nil {
-					log.Warnf("failed FetchBlockShaByHeight(%v): %v", h, err)
-					return
-				}
-				blk, err := db.FetchBlockBySha(sha)
-				if err != nil {
-					log.Warnf("failed FetchBlockBySha(%v) - h %v: %v", sha, h, err)
-					return
-				}
-
-				blockChan <- blk
-			}
-			blockWg.Done()
-		}()
-	}
-	go func() {
-		blockWg.Wait()
-		close(blockChan)
-	}()
-
-	var sigWg sync.WaitGroup
-	for i := 0; i <= 10; i++ {
-		sigWg.Add(1)
-		go func() {
-			for blk := range blockChan {
-				mblk := blk.MsgBlock()
-				for i, tx := range mblk.Transactions {
-					if btcchain.IsCoinBase(btcutil.NewTx(tx)) {
-						continue
-					}
-
-					for t, txin := range tx.TxIn {
-						dataSlice, err := btcscript.PushedData(txin.SignatureScript)
-						if err != nil {
-							continue
-						}
-
-						for d, data := range dataSlice {
-							signature, err := btcec.ParseSignature(data, btcec.S256())
-							if err != nil {
-								continue
-							}
-
-							sigChan <- &rData{
-								sig:  signature,
-								H:    blk.Height(),
-								Tx:   i,
-								TxIn: t,
-								Data: d,
-							}
-						}
-					}
-				}
-			}
-			sigWg.Done()
-		}()
+func newWalker(log btclog.Logger, src rscan.Source, workers int) *walker {
+	_, maxHeigth, err := src.Tip()
+	if err != nil {
+		log.Warnf("failed to get the tip: %v", err)
+		return nil
 	}
-	go func() {
-		sigWg.Wait()
-		close(sigChan)
-	}()
 
-	return sigChan
-}
-
-func search(log btclog.Logger, db btcdb.Db) map[string][]*rData {
 	// Setup signal handler
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
 
-	// Potential optimisation: keep the bloom filter between runs
-	filter := dablooms.NewScalingBloom(bloomSize, bloomRate, "blockchainr_bloom.bin")
-	if filter == nil {
-		log.Warn("dablooms.NewScalingBloom failed")
-		return nil
+	return &walker{
+		log:        log,
+		src:        src,
+		maxHeigth:  maxHeigth,
+		workers:    workers,
+		end:        maxHeigth + 1,
+		signalChan: signalChan,
+		stop:       make(chan struct{}),
 	}
+}
 
-	potentialValues := make(stringSet)
-	rMap := make(map[string][]*rData)
-
-	_, maxHeigth, err := db.NewestSha()
-	if err != nil {
-		log.Warnf("db NewestSha failed: %v", err)
-		return nil
+// interrupt stops the running walk. The blocks already fetched are still
+// processed.
+func (w *walker) interrupt() {
+	if !w.interrupted {
+		w.interrupted = true
+		close(w.stop)
 	}
+}
 
-	for step := 1; step <= 2; step++ {
-		lastTime := time.Now()
-		lastSig := int64(0)
-		sigCounter := int64(0)
-		matches := int64(0)
-		ticker := time.Tick(tickFreq * time.Second)
-
-		signatures := getSignatures(maxHeigth, log, db)
-		for rd := range signatures {
-			select {
-			case s := <-signalChan:
-				log.Infof("Step %v - signal %v - %v sigs in %.2fs, %v matches, %v total, block %v of %v",
-					step, s, sigCounter-lastSig, time.Since(lastTime).Seconds(),
-					matches, sigCounter, rd.H, maxHeigth)
-
-				if s == syscall.SIGINT || s == syscall.SIGTERM {
-					return rMap
-				}
-
-			case <-ticker:
-				log.Infof("Step %v - %v sigs in %.2fs, %v matches, %v total, block %v of %v",
-					step, sigCounter-lastSig, time.Since(lastTime).Seconds(),
-					matches, sigCounter, rd.H, maxHeigth)
-				lastTime = time.Now()
-				lastSig = sigCounter
-
-			default:
-				break
-			}
+func (w *walker) close() {
+	signal.Stop(w.signalChan)
+}
 
-			// Potential optimisation: store in potentialValues also the block
-			// height, and if step 2 finds the same h first, it's a bloom
-			// false positive
-			if step == 1 {
-				b := rd.sig.R.Bytes()
-				if filter.Check(b) {
-					matches++
-					potentialValues.Add(rd.sig.R.String())
-				} else {
-					if !filter.Add(b, 1) {
-						log.Warn("Add failed (?)")
-					}
-				}
-			} else if step == 2 {
-				if potentialValues.Contains(rd.sig.R.String()) {
-					matches++
-					rMap[rd.sig.R.String()] = append(rMap[rd.sig.R.String()], rd)
-				}
+// walk calls fn for all the signatures in the blocks [start, end), in
+// chain order, and returns the height below which all blocks were
+// processed. fn reports whether the signature is a match. If a block
+// can't be fetched the walk is interrupted.
+func (w *walker) walk(step int, start, end int64, fn func(rd *rscan.Signature) bool) int64 {
+	lastTime := time.Now()
+	lastSig := int64(0)
+	sigCounter := int64(0)
+	matches := int64(0)
+	ticker := time.Tick(tickFreq * time.Second)
+
+	signatures, result, rejected := rscan.Scan(w.src, start, end, w.workers, w.stop, w.log)
+	for rd := range signatures {
+		select {
+		case s := <-w.signalChan:
+			w.log.Infof("Step %v - signal %v - %v sigs in %.2fs, %v matches, %v total, block %v of %v",
+				step, s, sigCounter-lastSig, time.Since(lastTime).Seconds(),
+				matches, sigCounter, rd.H, w.maxHeigth)
+
+			if (s == syscall.SIGINT || s == syscall.SIGTERM) && !w.interrupted {
+				w.log.Infof("Step %v - stopping, waiting for the pending blocks", step)
+				w.interrupt()
 			}
-			sigCounter++
+
+		case <-ticker:
+			w.log.Infof("Step %v - %v sigs in %.2fs, %v matches, %v total, %v rejected, block %v of %v",
+				step, sigCounter-lastSig, time.Since(lastTime).Seconds(),
+				matches, sigCounter, atomic.LoadInt64(rejected), rd.H, w.maxHeigth)
+			lastTime = time.Now()
+			lastSig = sigCounter
+
+		default:
+			break
 		}
 
-		if *memprofile != "" {
-			f, err := os.Create(fmt.Sprintf("%s.%d", *memprofile, step))
-			if err != nil {
-				log.Warnf("open memprofile failed: %v", err)
-				return nil
-			}
+		if fn(rd) {
+			matches++
+		}
+		sigCounter++
+	}
+	res := <-result
+	h := res.Reached
+	if res.Err != nil {
+		w.log.Warnf("Step %v - failed to fetch block %v: %v", step, h, res.Err)
+		w.interrupt()
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(fmt.Sprintf("%s.%d", *memprofile, step))
+		if err != nil {
+			w.log.Warnf("open memprofile failed: %v", err)
+		} else {
 			pprof.WriteHeapProfile(f)
 			f.Close()
 		}
+	}
+
+	if w.interrupted {
+		w.log.Infof("Step %v interrupted at block %v - %v signatures processed - %v matches - %v pushes rejected",
+			step, h, sigCounter, matches, atomic.LoadInt64(rejected))
+	} else {
+		w.log.Infof("Step %v done - %v signatures processed - %v matches - %v pushes rejected",
+			step, sigCounter, matches, atomic.LoadInt64(rejected))
+	}
+
+	return h
+}
+
+// search finds the repeated R values with two passes over the chain: the
+// first one adds the new blocks to the bloom filter, the second one
+// searches the new blocks for all the potential values, and the old ones
+// only for the values that were first flagged by the bloom filter in the
+// new blocks.
+func search(state *scanState, filter *bloom.ScalingBloom, w *walker) {
+	// Potential optimisation: store in Pending also the block
+	// height, and if step 2 finds the same h first, it's a bloom
+	// false positive
+	h := w.walk(1, state.Bloomed, w.end, func(rd *rscan.Signature) bool {
+		b := rd.Sig.R.Bytes()
+		if filter.Check(b) {
+			if !state.Potential.Contains(rd.Sig.R.String()) {
+				state.Pending.Add(rd.Sig.R.String())
+			}
+			return true
+		}
+
+		// The height is used as id, so that the filter scales
+		if err := filter.Add(b, uint64(rd.H)+1); err != nil {
+			w.log.Warnf("bloom Add failed: %v", err)
+		}
+		return false
+	})
+
+	// All the blocks below h are in the filter, even if interrupted
+	state.Bloomed = h
+	if w.interrupted {
+		return
+	}
+
+	start := state.Height
+	if len(state.Pending) != 0 {
+		start = w.start
+	}
+
+	found := make(map[string][]*rscan.Signature)
+	w.walk(2, start, state.Bloomed, func(rd *rscan.Signature) bool {
+		r := rd.Sig.R.String()
+		if state.Pending.Contains(r) ||
+			(rd.H >= state.Height && state.Potential.Contains(r)) {
+			found[r] = append(found[r], rd)
+			return true
+		}
+		return false
+	})
 
-		log.Infof("Step %v done - %v signatures processed - %v matches",
-			step, sigCounter, matches)
+	// A partial step 2 can't be checkpointed, it will be redone
+	if w.interrupted {
+		return
+	}
+
+	for r, rds := range found {
+		state.Matches[r] = append(state.Matches[r], rds...)
+	}
+	for r := range state.Pending {
+		state.Potential.Add(r)
 	}
-	return rMap
+	state.Pending = make(stringSet)
+	state.Height = state.Bloomed
 }
 
 var (
@@ -263,11 +256,35 @@ var (
 
 func main() {
 	var (
-		dataDir = flag.String("datadir", filepath.Join(btcutil.AppDataDir("btcd", false), "data"), "BTCD: Data directory")
-		dbType  = flag.String("dbtype", "leveldb", "BTCD: Database backend")
+		testnet = flag.Bool("testnet", false, "BTCD: Use the test network")
+		regtest = flag.Bool("regtest", false, "BTCD: Use the regression test network")
+
+		stateFile = flag.String("state", "blockchainr_state.json", "checkpoint of the previous runs")
+		bloomFile = flag.String("bloom", "blockchainr_bloom.bin", "bloom filter of the previous runs")
+		indexDir  = flag.String("index", "", "exact R index directory, replaces the bloom filter and the second pass")
+		lookupR   = flag.String("lookup", "", "print all the uses of this R value, in hex as in blockchainr.json, from the -index and exit")
+		mergeDirs = flag.String("merge", "", "comma separated shard indexes to merge into the -index, instead of scanning")
+		follow    = flag.Bool("follow", false, "after the scan, keep the -index updated from the btcd websocket and alert on every reuse")
+		alerts    = flag.String("alerts", "blockchainr_alerts.jsonl", "file the -follow alerts are appended to")
+		workers   = flag.Int("workers", runtime.GOMAXPROCS(0), "goroutines fetching and parsing blocks")
+		malleable = flag.Bool("malleability", false, "verify the reused R values, and mark the malleated copies of a spend in the results")
+
+		r   scanRange
+		cfg rscan.SourceConfig
 	)
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Int64Var(&r.Start, "start", 0, "first block height to scan")
+	flag.Int64Var(&r.End, "end", -1, "last block height to scan, -1 for the tip")
+	flag.StringVar(&r.StartHash, "starthash", "", "first block hash to scan, overrides -start")
+	flag.StringVar(&r.EndHash, "endhash", "", "last block hash to scan, overrides -end")
+	flag.StringVar(&r.Shard, "shard", "", "scan only the i-th of n slices of the range, as i/n (requires -end or -endhash, and -index to merge)")
 	flag.Parse()
 
+	if flag.Arg(0) == "merge" {
+		mergeCommand(flag.Args()[1:])
+		return
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -277,26 +294,263 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	// Setup btcdb
-	log, db, dbCleanup := btcdbSetup(*dataDir, *dbType)
-	defer dbCleanup()
+	if *lookupR != "" {
+		if err := lookup(*indexDir, *lookupR); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	duplicates := search(log, db)
+	// Setup the block source
+	net, netDir := rscan.NetParams(*testnet, *regtest)
+	cfg.Net, cfg.NetDir = net, netDir
+	log, src, srcCleanup := sourceSetup(&cfg)
+	if src == nil {
+		return
+	}
+	defer srcCleanup()
 
-	realDuplicates := make(map[string][]*rData)
-	for k, v := range duplicates {
-		if len(v) > 1 {
-			realDuplicates[k] = v
+	w := newWalker(log, src, *workers)
+	if w == nil {
+		return
+	}
+	defer w.close()
+
+	start, end, fixedEnd, err := r.resolve(src, w.maxHeigth)
+	if err != nil {
+		log.Warnf("invalid range: %v", err)
+		return
+	}
+	w.start, w.end = start, end
+	if !fixedEnd {
+		end = -1
+	}
+	if *follow && (*indexDir == "" || fixedEnd) {
+		log.Warnf("-follow requires -index, and can't be used with -end")
+		return
+	}
+
+	if *mergeDirs != "" {
+		if err := merge(log, *indexDir, strings.Split(*mergeDirs, ","), src, net, w.start, w.end, *malleable); err != nil {
+			log.Warnf("%v", err)
 		}
+		return
 	}
 
-	resultsFile, err := os.Create("blockchainr.json")
+	log.Infof("scanning blocks [%v, %v)", w.start, w.end)
+
+	// Load the checkpoint and the bloom filter or index of the previous run
+	exact := *indexDir != ""
+	state, fresh, err := loadState(*stateFile)
 	if err != nil {
-		log.Warnf("failed to create blockchainr.json: %v", err)
+		log.Warnf("failed to load %v: %v", *stateFile, err)
 		return
 	}
-	if json.NewEncoder(resultsFile).Encode(realDuplicates) != nil {
-		log.Warnf("failed to Encode the result: %v", err)
+	if !fresh && state.Net != net.Name {
+		log.Warnf("%v was saved for %v, scanning from scratch", *stateFile, state.Net)
+		state, fresh = newScanState(), true
+	}
+	if !fresh && state.Exact != exact {
+		log.Warnf("%v was saved in a different mode, scanning from scratch", *stateFile)
+		state, fresh = newScanState(), true
+	}
+	if !fresh && (state.Start != start || state.End != end) {
+		log.Warnf("%v was saved for a different range, scanning from scratch", *stateFile)
+		state, fresh = newScanState(), true
+	}
+	if _, err := os.Stat(*bloomFile); os.IsNotExist(err) && !fresh && !exact {
+		log.Warnf("%v is missing, scanning from scratch", *bloomFile)
+		state, fresh = newScanState(), true
+	}
+	if _, err := os.Stat(*indexDir); os.IsNotExist(err) && !fresh && exact {
+		log.Warnf("%v is missing, scanning from scratch", *indexDir)
+		state, fresh = newScanState(), true
+	}
+
+	// Undo the blocks of the previous runs that left the main chain
+	undoFrom := int64(-1)
+	if !fresh {
+		switch fork := state.forkPoint(src); {
+		case fork < 0:
+			log.Warnf("%v was saved on a branch that forked more than %v blocks ago, scanning from scratch",
+				*stateFile, branchDepth)
+			state, fresh = newScanState(), true
+			undoFrom = 0
+		case fork <= state.TipHeight:
+			log.Warnf("the chain moved off block %v at height %v, undoing the blocks from height %v",
+				state.Tip, state.TipHeight, fork)
+			state.rewind(fork)
+			undoFrom = fork
+		}
+	}
+
+	state.Net, state.Exact = net.Name, exact
+	if fresh {
+		state.Start, state.End = start, end
+		state.Bloomed, state.Height = start, start
+	} else {
+		log.Infof("resuming from block %v", state.Height)
+	}
+
+	if exact {
+		idx, err := rscan.OpenIndex(*indexDir)
+		if err != nil {
+			log.Warnf("failed to open the index: %v", err)
+			return
+		}
+		defer idx.Close()
+
+		if undoFrom >= 0 {
+			log.Infof("removing the signatures from height %v from the index, with a full pass", undoFrom)
+			n, err := idx.DeleteFrom(undoFrom)
+			if err != nil {
+				log.Warnf("failed to undo the index: %v", err)
+				return
+			}
+			log.Infof("removed %v signatures", n)
+		}
+
+		searchIndex(state, idx, w)
+		if err := state.recordBranch(src); err != nil {
+			log.Warnf("failed to record the branch: %v", err)
+			return
+		}
+
+		if *follow && !w.interrupted {
+			if err := saveState(*stateFile, state); err != nil {
+				log.Warnf("failed to save %v: %v", *stateFile, err)
+				return
+			}
+			res := scanResults(log, src, net, state, *malleable)
+			if res == nil {
+				return
+			}
+			followChain(log, &cfg, idx, state, res, *stateFile, *alerts, *malleable, w.signalChan)
+			return
+		}
+	} else {
+		var filter *bloom.ScalingBloom
+		if fresh {
+			filter, err = bloom.NewScalingBloom(bloomSize, bloomRate, *bloomFile)
+		} else {
+			filter, err = bloom.NewScalingBloomFromFile(bloomSize, bloomRate, *bloomFile)
+		}
+		if err != nil {
+			log.Warnf("failed to open the bloom filter: %v", err)
+			return
+		}
+		defer filter.Close()
+
+		// The bloom filter can't forget the disconnected blocks: their
+		// values will just be searched for again in the second pass
+		search(state, filter, w)
+		if err := state.recordBranch(src); err != nil {
+			log.Warnf("failed to record the branch: %v", err)
+			return
+		}
+
+		if err := filter.Flush(); err != nil {
+			log.Warnf("failed to flush %v: %v", *bloomFile, err)
+			return
+		}
+	}
+
+	if err := saveState(*stateFile, state); err != nil {
+		log.Warnf("failed to save %v: %v", *stateFile, err)
 		return
 	}
+
+	scanResults(log, src, net, state, *malleable)
+}
+
+// scanResults builds the results of the scan in state, and writes them to
+// blockchainr.json. It returns nil if that failed.
+func scanResults(log btclog.Logger, src rscan.Source, net *btcnet.Params, state *scanState, malleable bool) *results {
+	res, err := buildResults(src, net, state.Start, state.Height, state.duplicates())
+	if err != nil {
+		log.Warnf("failed to build the results: %v", err)
+		return nil
+	}
+	if malleable {
+		if err := markMalleated(src, res); err != nil {
+			log.Warnf("failed to check for malleated signatures: %v", err)
+			return nil
+		}
+	}
+	if err := writeResults("blockchainr.json", res); err != nil {
+		log.Warnf("failed to write the results: %v", err)
+		return nil
+	}
+	return res
+}
+
+// lookup prints all the uses of an R value, in hex as in
+// blockchainr.json, from the exact index.
+func lookup(indexDir, r string) error {
+	b, err := hex.DecodeString(r)
+	if err != nil || len(b) == 0 || len(b) > 32 {
+		return fmt.Errorf("invalid R value: %v", r)
+	}
+	R := new(big.Int).SetBytes(b)
+	if indexDir == "" {
+		return fmt.Errorf("-lookup requires -index")
+	}
+
+	idx, err := rscan.OpenIndex(indexDir)
+	if err != nil {
+		return fmt.Errorf("failed to open the index: %v", err)
+	}
+	defer idx.Close()
+
+	rds, err := idx.Lookup(R)
+	if err != nil {
+		return fmt.Errorf("index lookup failed: %v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(rds); err != nil {
+		return fmt.Errorf("failed to Encode the result: %v", err)
+	}
+	return nil
+}
+
+// merge copies the shard indexes into indexDir, and writes all the
+// repeated R values found in it to blockchainr.json, marking the
+// malleated copies if malleable. The shards are expected to cover the
+// blocks [start, end).
+func merge(log btclog.Logger, indexDir string, shards []string, src rscan.Source, net *btcnet.Params,
+	start, end int64, malleable bool) error {
+
+	if indexDir == "" {
+		return fmt.Errorf("-merge requires -index")
+	}
+
+	idx, err := rscan.OpenIndex(indexDir)
+	if err != nil {
+		return fmt.Errorf("failed to open the index: %v", err)
+	}
+	defer idx.Close()
+
+	for _, shard := range shards {
+		log.Infof("merging %v", shard)
+		if err := idx.Merge(shard); err != nil {
+			return fmt.Errorf("failed to merge %v: %v", shard, err)
+		}
+	}
+
+	duplicates, err := idx.Repeated()
+	if err != nil {
+		return fmt.Errorf("index scan failed: %v", err)
+	}
+	res, err := buildResults(src, net, start, end, duplicates)
+	if err != nil {
+		return fmt.Errorf("failed to build the results: %v", err)
+	}
+	if malleable {
+		if err := markMalleated(src, res); err != nil {
+			return fmt.Errorf("failed to check for malleated signatures: %v", err)
+		}
+	}
+	if err := writeResults("blockchainr.json", res); err != nil {
+		return fmt.Errorf("failed to write the results: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"rscan"
+
+	"github.com/conformal/btcwire"
+)
+
+// scanRange selects the blocks to scan. Heights are inclusive, and End
+// is -1 to follow the chain tip. Hashes, if set, override the heights.
+type scanRange struct {
+	Start, End         int64
+	StartHash, EndHash string
+
+	// Shard is in the form "i/n", and selects the i-th of n equal slices
+	// (counting from 0) of the range above.
+	Shard string
+}
+
+func hashHeight(src rscan.Source, hash string) (int64, error) {
+	sha, err := btcwire.NewShaHashFromStr(hash)
+	if err != nil {
+		return 0, err
+	}
+	h, err := src.BlockHeight(sha)
+	if err != nil {
+		return 0, fmt.Errorf("block %v: %v", hash, err)
+	}
+	return h, nil
+}
+
+// resolve returns the half-open interval of heights [start, end) to
+// scan, given the current tip. fixedEnd reports whether end does not
+// depend on the tip.
+func (r *scanRange) resolve(src rscan.Source, maxHeigth int64) (start, end int64, fixedEnd bool, err error) {
+	start, end = r.Start, r.End+1
+	fixedEnd = r.End >= 0
+
+	if r.StartHash != "" {
+		if start, err = hashHeight(src, r.StartHash); err != nil {
+			return
+		}
+	}
+	if r.EndHash != "" {
+		var h int64
+		if h, err = hashHeight(src, r.EndHash); err != nil {
+			return
+		}
+		end, fixedEnd = h+1, true
+	}
+	if r.Shard != "" {
+		// All shards must agree on the range, and nodes at different
+		// heights don't agree on their tip
+		if !fixedEnd {
+			err = fmt.Errorf("-shard requires -end or -endhash")
+			return
+		}
+		if end > maxHeigth+1 {
+			err = fmt.Errorf("range ends at %v, past the tip %v", end-1, maxHeigth)
+			return
+		}
+
+		var i, n int64
+		if _, err = fmt.Sscanf(r.Shard, "%d/%d", &i, &n); err != nil {
+			err = fmt.Errorf("invalid shard %q: %v", r.Shard, err)
+			return
+		}
+		if n <= 0 || i < 0 || i >= n {
+			err = fmt.Errorf("invalid shard %q", r.Shard)
+			return
+		}
+
+		size := end - start
+		start, end = start+i*size/n, start+(i+1)*size/n
+	} else if !fixedEnd || end > maxHeigth+1 {
+		end = maxHeigth + 1
+	}
+
+	if start < 0 || start > end {
+		err = fmt.Errorf("invalid range [%v, %v)", start, end)
+	}
+	return
+}
@@ -0,0 +1,449 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+
+	"rscan"
+
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+)
+
+// resultsVersion is the version of the blockchainr.json schema.
+const resultsVersion = 1
+
+// results is the content of blockchainr.json.
+type results struct {
+	Version int    `json:"version"`
+	Net     string `json:"net"`
+
+	// The scanned heights, [Start, End), and the hash of the block at
+	// End-1 at the time of the scan
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Tip   string `json:"tip"`
+
+	// Duplicates maps the hex R values to all their uses, and Wallets to
+	// the likely wallet family of those uses
+	Duplicates map[string][]*occurrence `json:"duplicates"`
+	Wallets    map[string]string        `json:"wallets"`
+}
+
+// occurrence is a single use of a R value, in the push Push of the input
+// TxIn of the transaction TxIndex of a block.
+type occurrence struct {
+	Height    int64  `json:"height"`
+	BlockHash string `json:"block"`
+	TxIndex   int    `json:"txIndex"`
+	TxID      string `json:"txid"`
+	TxIn      int    `json:"txIn"`
+	Push      int    `json:"push"`
+
+	S        string `json:"s"`
+	HashType byte   `json:"hashType"`
+
+	// HighS is set if S is in the high half of the curve order, where
+	// anyone can replace it with N-S and change the txid
+	HighS bool `json:"highS"`
+
+	// MalleatedOf is, with -malleability, the txid:txIn of an earlier
+	// use that signs the same hash with the same key: this one is the
+	// same signature, with S or N-S, in a copy of the spend
+	MalleatedOf string `json:"malleatedOf,omitempty"`
+
+	// Class is the class of the script checking the signature, the
+	// redeem script if P2SH, and PubKey the hex key it belongs to, if
+	// the sigScript tells
+	Class  string `json:"class"`
+	P2SH   bool   `json:"p2sh"`
+	PubKey string `json:"pubKey,omitempty"`
+
+	// Wallet is the likely wallet family that made the signature, from
+	// rscan.Fingerprint
+	Wallet string `json:"wallet"`
+}
+
+func (o *occurrence) key() string {
+	return fmt.Sprintf("%v:%v:%v:%v", o.BlockHash, o.TxID, o.TxIn, o.Push)
+}
+
+// occurrences sorts in chain order.
+type occurrences []*occurrence
+
+func (s occurrences) Len() int      { return len(s) }
+func (s occurrences) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s occurrences) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.Height != b.Height {
+		return a.Height < b.Height
+	}
+	if a.TxIndex != b.TxIndex {
+		return a.TxIndex < b.TxIndex
+	}
+	if a.TxIn != b.TxIn {
+		return a.TxIn < b.TxIn
+	}
+	return a.Push < b.Push
+}
+
+// buildResults fetches from src the details of the duplicates found in
+// the blocks [start, end).
+func buildResults(src rscan.Source, net *btcnet.Params, start, end int64, duplicates map[string][]*rscan.Signature) (*results, error) {
+	res := &results{
+		Version:    resultsVersion,
+		Net:        net.Name,
+		Start:      start,
+		End:        end,
+		Duplicates: make(map[string][]*occurrence),
+	}
+
+	if end > 0 {
+		blk, err := src.BlockByHeight(end - 1)
+		if err != nil {
+			return nil, err
+		}
+		sha, err := blk.Sha()
+		if err != nil {
+			return nil, err
+		}
+		res.Tip = sha.String()
+	}
+
+	blocks := make(map[int64]*btcutil.Block)
+	fees := make(map[string]int64)
+	for r, rds := range duplicates {
+		R, ok := new(big.Int).SetString(r, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid R value %v", r)
+		}
+		if _, err := addOccurrences(src, res, R, rds, blocks, fees); err != nil {
+			return nil, err
+		}
+	}
+	labelClusters(res)
+
+	return res, nil
+}
+
+// addOccurrences adds to res the uses rds of R it doesn't have yet, and
+// returns the key of R. blocks caches the blocks fetched from src by
+// height, and fees is the cache of fingerprint.
+func addOccurrences(src rscan.Source, res *results, R *big.Int, rds []*rscan.Signature,
+	blocks map[int64]*btcutil.Block, fees map[string]int64) (string, error) {
+
+	key := hex.EncodeToString(rscan.RPrefix(R))
+rdLoop:
+	for _, rd := range rds {
+		for _, o := range res.Duplicates[key] {
+			if o.Height == rd.H && o.TxIndex == rd.Tx && o.TxIn == rd.TxIn && o.Push == rd.Data {
+				continue rdLoop
+			}
+		}
+
+		blk, ok := blocks[rd.H]
+		if !ok {
+			var err error
+			blk, err = src.BlockByHeight(rd.H)
+			if err != nil {
+				return "", err
+			}
+			blocks[rd.H] = blk
+		}
+
+		o, err := newOccurrence(blk, rd)
+		if err != nil {
+			return "", err
+		}
+		if err := fingerprint(src, blk, rd, o, fees); err != nil {
+			return "", err
+		}
+		res.Duplicates[key] = append(res.Duplicates[key], o)
+	}
+	sort.Sort(occurrences(res.Duplicates[key]))
+	return key, nil
+}
+
+// rewindResults drops the occurrences in the blocks from height up, and
+// the R values left with a single use.
+func rewindResults(res *results, height int64) {
+	for key, occs := range res.Duplicates {
+		var kept []*occurrence
+		for _, o := range occs {
+			if o.Height < height {
+				kept = append(kept, o)
+			}
+		}
+		if len(kept) > 1 {
+			res.Duplicates[key] = kept
+		} else {
+			delete(res.Duplicates, key)
+		}
+	}
+	if res.End > height {
+		res.End = height
+	}
+}
+
+// fingerprint sets the wallet of o, the occurrence of rd in blk. fees
+// caches the fees of the transactions by txid, -1 if the previous outputs
+// can't be fetched, as with a Source that only has part of the chain.
+func fingerprint(src rscan.Source, blk *btcutil.Block, rd *rscan.Signature, o *occurrence, fees map[string]int64) error {
+	tx, err := blk.Tx(rd.Tx)
+	if err != nil {
+		return err
+	}
+	fee, ok := fees[o.TxID]
+	if !ok {
+		fee, err = rscan.TxFee(src, tx.MsgTx())
+		if err != nil {
+			fee = -1
+		}
+		fees[o.TxID] = fee
+	}
+	f, err := rscan.Fingerprint(tx.MsgTx(), rd.TxIn, rd.Data, fee)
+	if err != nil {
+		return fmt.Errorf("h %v tx %v: %v", rd.H, rd.Tx, err)
+	}
+	o.Wallet = f.Wallet()
+	return nil
+}
+
+// labelClusters sets the wallet family of each R value from its
+// occurrences.
+func labelClusters(res *results) {
+	res.Wallets = make(map[string]string)
+	for r, occs := range res.Duplicates {
+		var wallets []string
+		for _, o := range occs {
+			wallets = append(wallets, o.Wallet)
+		}
+		res.Wallets[r] = rscan.LikelyWallet(wallets)
+	}
+}
+
+func newOccurrence(blk *btcutil.Block, rd *rscan.Signature) (*occurrence, error) {
+	sha, err := blk.Sha()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := blk.Tx(rd.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("h %v: %v", rd.H, err)
+	}
+	if rd.TxIn >= len(tx.MsgTx().TxIn) {
+		return nil, fmt.Errorf("h %v tx %v: no input %v", rd.H, rd.Tx, rd.TxIn)
+	}
+	var sig *rscan.Signature
+	sigs, _ := rscan.SigScriptSignatures(tx.MsgTx().TxIn[rd.TxIn].SignatureScript)
+	for _, s := range sigs {
+		if s.Data == rd.Data {
+			sig = s
+		}
+	}
+	if sig == nil {
+		return nil, fmt.Errorf("h %v tx %v: bad push %v", rd.H, rd.Tx, rd.Data)
+	}
+
+	return &occurrence{
+		Height:    rd.H,
+		BlockHash: sha.String(),
+		TxIndex:   rd.Tx,
+		TxID:      tx.Sha().String(),
+		TxIn:      rd.TxIn,
+		Push:      rd.Data,
+		S:         hex.EncodeToString(sig.Sig.S.Bytes()),
+		HashType:  sig.HashType,
+		HighS:     !sig.Sig.IsLowS(),
+		Class:     btcscript.ScriptClassToName[sig.Class],
+		P2SH:      sig.P2SH,
+		PubKey:    hex.EncodeToString(sig.PubKey),
+	}, nil
+}
+
+// markMalleated sets MalleatedOf on the occurrences that are malleated
+// copies of an earlier one. Telling them apart needs the hash each
+// signature signs, so every occurrence is verified, fetching the output
+// it spends from src.
+func markMalleated(src rscan.Source, res *results) error {
+	verified := make(map[string]*rscan.Verified)
+	for _, occs := range res.Duplicates {
+		if err := markCopies(src, occs, verified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markCopies sets MalleatedOf on the occurrences of a R value, in chain
+// order, that are copies of an earlier one. verified caches the result of
+// verifyOccurrence by occurrence key.
+func markCopies(src rscan.Source, occs []*occurrence, verified map[string]*rscan.Verified) error {
+	for i, o := range occs {
+		v, ok := verified[o.key()]
+		if !ok {
+			var err error
+			v, err = verifyOccurrence(src, o)
+			if err != nil {
+				return err
+			}
+			verified[o.key()] = v
+		}
+		o.MalleatedOf = ""
+		if v == nil {
+			continue
+		}
+		for _, prev := range occs[:i] {
+			if pv := verified[prev.key()]; pv != nil && rscan.Malleated(pv, v) {
+				o.MalleatedOf = fmt.Sprintf("%v:%v", prev.TxID, prev.TxIn)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// verifyOccurrence checks the signature of o. A signature that doesn't
+// verify, like one spending an unsupported script, or whose previous
+// output can't be fetched, is returned as nil.
+func verifyOccurrence(src rscan.Source, o *occurrence) (*rscan.Verified, error) {
+	blk, err := src.BlockByHeight(o.Height)
+	if err != nil {
+		return nil, err
+	}
+	btx, err := blk.Tx(o.TxIndex)
+	if err != nil {
+		return nil, fmt.Errorf("h %v: %v", o.Height, err)
+	}
+	tx := btx.MsgTx()
+	if o.TxIn >= len(tx.TxIn) {
+		return nil, fmt.Errorf("tx %v: no input %v", o.TxID, o.TxIn)
+	}
+	prev := tx.TxIn[o.TxIn].PreviousOutPoint
+	// Like fingerprint, a previous output that can't be fetched makes
+	// the occurrence unknown rather than failing all the results
+	txPrev, _, err := src.TxBySha(&prev.Hash)
+	if err != nil {
+		log.Printf("can't verify %v:%v, previous tx %v: %v", o.TxID, o.TxIn, prev.Hash, err)
+		return nil, nil
+	}
+	if int(prev.Index) >= len(txPrev.TxOut) {
+		log.Printf("can't verify %v:%v, tx %v has no output %v", o.TxID, o.TxIn, prev.Hash, prev.Index)
+		return nil, nil
+	}
+	v, err := rscan.Verify(tx, o.TxIn, txPrev.TxOut[prev.Index].PkScript, o.Push)
+	if err != nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+func readResults(filename string) (*results, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := &results{}
+	if err := json.NewDecoder(f).Decode(res); err != nil {
+		return nil, err
+	}
+	if res.Version != resultsVersion {
+		return nil, fmt.Errorf("%v: unsupported version %v", filename, res.Version)
+	}
+	return res, nil
+}
+
+func writeResults(filename string, res *results) error {
+	resultsFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer resultsFile.Close()
+	return json.NewEncoder(resultsFile).Encode(res)
+}
+
+// mergeResults combines a and b, removing the repeated occurrences. The
+// range covers both, and the tip is the one of the result ending last.
+//
+// Only the values that were repeated in a or b are known: a value used
+// once in each is only found by merging the shard indexes with -merge.
+func mergeResults(a, b *results) *results {
+	res := &results{
+		Version:    resultsVersion,
+		Net:        a.Net,
+		Start:      a.Start,
+		End:        a.End,
+		Tip:        a.Tip,
+		Duplicates: make(map[string][]*occurrence),
+	}
+	if b.Start < res.Start {
+		res.Start = b.Start
+	}
+	if b.End > res.End {
+		res.End, res.Tip = b.End, b.Tip
+	}
+
+	for _, dups := range []map[string][]*occurrence{a.Duplicates, b.Duplicates} {
+		for r, occs := range dups {
+		occLoop:
+			for _, o := range occs {
+				for _, old := range res.Duplicates[r] {
+					if old.key() == o.key() {
+						continue occLoop
+					}
+				}
+				res.Duplicates[r] = append(res.Duplicates[r], o)
+			}
+		}
+	}
+	for _, occs := range res.Duplicates {
+		sort.Sort(occurrences(occs))
+	}
+	labelClusters(res)
+
+	return res
+}
+
+// mergeCommand implements "blockchainr merge [-o file] results.json...".
+func mergeCommand(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "blockchainr.json", "merged output file")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		log.Fatal("usage: blockchainr merge [-o file] results.json...")
+	}
+
+	var res *results
+	for _, filename := range fs.Args() {
+		r, err := readResults(filename)
+		if err != nil {
+			log.Fatalf("failed to read %v: %v", filename, err)
+		}
+		if res != nil && r.Net != res.Net {
+			log.Fatalf("%v is for %v, not %v", filename, r.Net, res.Net)
+		}
+		if res == nil {
+			res = r
+		} else {
+			res = mergeResults(res, r)
+		}
+	}
+
+	if err := writeResults(*output, res); err != nil {
+		log.Fatalf("failed to write %v: %v", *output, err)
+	}
+}
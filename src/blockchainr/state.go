@@ -0,0 +1,205 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"rscan"
+)
+
+// scanState is the checkpoint persisted between runs, next to the bloom
+// filter file.
+//
+// All the blocks below Bloomed have been added to the bloom filter, and
+// all the blocks below Height have been searched for the values in
+// Potential. Pending holds the bloom hits found above Height, that still
+// have to be searched for in the whole chain.
+//
+// With the exact index (Exact is true) only Height and Matches are used,
+// and Matches only holds the repeated values.
+//
+// Tip is the hash of the last block processed, at TipHeight, and Branch
+// the hashes of the last branchDepth blocks up to it, to find where the
+// chain forked if it moved to another branch since.
+type scanState struct {
+	Net   string
+	Exact bool
+
+	// The range the state was built for, see scanRange.resolve
+	Start, End int64
+
+	Bloomed   int64
+	Height    int64
+	Potential stringSet
+	Pending   stringSet
+	Matches   map[string][]*rscan.Signature
+
+	Tip       string
+	TipHeight int64
+	Branch    []string
+}
+
+// branchDepth is how many block hashes are kept in scanState.Branch, and
+// so the deepest reorg that can be undone.
+const branchDepth = 100
+
+func newScanState() *scanState {
+	return &scanState{
+		Potential: make(stringSet),
+		Pending:   make(stringSet),
+		Matches:   make(map[string][]*rscan.Signature),
+	}
+}
+
+// loadState reads the checkpoint from filename. If the file does not
+// exist, a fresh state is returned and fresh is true.
+func loadState(filename string) (state *scanState, fresh bool, err error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return newScanState(), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	state = newScanState()
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, false, err
+	}
+
+	// Maps serialized as null come back nil
+	if state.Potential == nil {
+		state.Potential = make(stringSet)
+	}
+	if state.Pending == nil {
+		state.Pending = make(stringSet)
+	}
+	if state.Matches == nil {
+		state.Matches = make(map[string][]*rscan.Signature)
+	}
+
+	return state, false, nil
+}
+
+// saveState atomically replaces filename with the current checkpoint.
+func saveState(filename string, state *scanState) error {
+	tmpName := filename + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(state); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// duplicates returns the R values that were found more than once.
+func (s *scanState) duplicates() map[string][]*rscan.Signature {
+	realDuplicates := make(map[string][]*rscan.Signature)
+	for k, v := range s.Matches {
+		if len(v) > 1 {
+			realDuplicates[k] = v
+		}
+	}
+	return realDuplicates
+}
+
+// extendBranch records sha as the block at height, the new tip.
+func (s *scanState) extendBranch(height int64, sha string) {
+	if len(s.Branch) != 0 && height != s.TipHeight+1 {
+		s.Branch = nil
+	}
+	s.Branch = append(s.Branch, sha)
+	if len(s.Branch) > branchDepth {
+		s.Branch = s.Branch[len(s.Branch)-branchDepth:]
+	}
+	s.Tip, s.TipHeight = sha, height
+}
+
+// recordBranch extends the branch up to the last block processed, from
+// src. It must be called after forkPoint checked that the branch is still
+// in the chain.
+func (s *scanState) recordBranch(src rscan.Source) error {
+	top := s.Height
+	if s.Bloomed > top {
+		top = s.Bloomed
+	}
+
+	from := s.TipHeight + 1
+	if len(s.Branch) == 0 || from < top-branchDepth {
+		from = top - branchDepth
+	}
+	if from < s.Start {
+		from = s.Start
+	}
+	for h := from; h < top; h++ {
+		sha, err := src.BlockSha(h)
+		if err != nil {
+			return err
+		}
+		s.extendBranch(h, sha.String())
+	}
+	return nil
+}
+
+// forkPoint returns the height of the first block of the branch that is
+// not in the chain of src anymore, TipHeight+1 if the whole branch is
+// still there, or -1 if the fork is deeper than the branch.
+func (s *scanState) forkPoint(src rscan.Source) int64 {
+	if len(s.Branch) == 0 {
+		return s.TipHeight + 1
+	}
+	for i := len(s.Branch) - 1; i >= 0; i-- {
+		h := s.TipHeight - int64(len(s.Branch)-1-i)
+		sha, err := src.BlockSha(h)
+		if err == nil && sha.String() == s.Branch[i] {
+			return h + 1
+		}
+	}
+	return -1
+}
+
+// rewind forgets the blocks from height up, and the matches found in
+// them. The bloom filter and the index have to be taken care of
+// separately.
+func (s *scanState) rewind(height int64) {
+	for r, rds := range s.Matches {
+		var kept []*rscan.Signature
+		for _, rd := range rds {
+			if rd.H < height {
+				kept = append(kept, rd)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.Matches, r)
+		} else {
+			s.Matches[r] = kept
+		}
+	}
+
+	if s.Height > height {
+		s.Height = height
+	}
+	if s.Bloomed > height {
+		s.Bloomed = height
+	}
+
+	n := len(s.Branch) - int(s.TipHeight-height+1)
+	if n <= 0 {
+		s.Branch, s.Tip = nil, ""
+	} else {
+		s.Branch = s.Branch[:n]
+		s.Tip = s.Branch[n-1]
+	}
+	s.TipHeight = height - 1
+}
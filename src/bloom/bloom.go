@@ -0,0 +1,374 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package bloom implements a scaling, counting bloom filter backed by a
+// memory mapped file, with the same semantics as bitly's dablooms.
+//
+// A ScalingBloom is a list of counting bloom filters, each one holding up
+// to capacity elements. When the newest one fills up a new one is
+// appended, with a tighter error rate, so that the overall false positive
+// rate stays below the requested one. Elements are added with an id: ids
+// are expected to grow over time, and an element can be removed by
+// passing the same id it was added with.
+//
+// The file format is not compatible with the C dablooms one.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"os"
+)
+
+const (
+	errorTighteningRatio = 0.5
+
+	scalingHeaderSize  = 48
+	countingHeaderSize = 16
+)
+
+var magic = [8]byte{'g', 'o', 'b', 'l', 'o', 'o', 'm', 1}
+
+var (
+	ErrBadMagic = errors.New("bloom: not a bloom filter file")
+	ErrBadSize  = errors.New("bloom: file size does not match the header")
+	ErrParams   = errors.New("bloom: file parameters do not match")
+)
+
+// countingBloom is a view on one of the filters in the mapped file.
+type countingBloom struct {
+	offset  int // of the header in the file
+	size    int // of the counters
+	buckets uint64
+	hashes  uint64
+}
+
+func newCountingBloom(capacity uint64, errorRate float64, offset int) *countingBloom {
+	buckets := uint64(math.Ceil(float64(capacity) * math.Abs(math.Log(errorRate)) /
+		(math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Ceil(math.Ln2 * float64(buckets) / float64(capacity)))
+	return &countingBloom{
+		offset:  offset,
+		size:    int((buckets + 1) / 2),
+		buckets: buckets,
+		hashes:  hashes,
+	}
+}
+
+func (cb *countingBloom) header(data []byte) []byte {
+	return data[cb.offset : cb.offset+countingHeaderSize]
+}
+
+func (cb *countingBloom) counters(data []byte) []byte {
+	start := cb.offset + countingHeaderSize
+	return data[start : start+cb.size]
+}
+
+// ScalingBloom is a scaling, counting bloom filter. It is not safe for
+// concurrent use.
+type ScalingBloom struct {
+	capacity  uint64
+	errorRate float64
+
+	file   *os.File
+	data   []byte
+	blooms []*countingBloom
+}
+
+// NewScalingBloom creates a new, empty filter in filename, overwriting
+// any existing file.
+func NewScalingBloom(capacity uint, errorRate float64, filename string) (*ScalingBloom, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &ScalingBloom{
+		capacity:  uint64(capacity),
+		errorRate: errorRate,
+		file:      f,
+	}
+
+	if err := sb.remap(scalingHeaderSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	copy(sb.data[0:8], magic[:])
+	binary.LittleEndian.PutUint64(sb.data[8:16], sb.capacity)
+	binary.LittleEndian.PutUint64(sb.data[16:24], math.Float64bits(errorRate))
+
+	if err := sb.grow(); err != nil {
+		sb.Close()
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+// NewScalingBloomFromFile opens a filter previously created with
+// NewScalingBloom. capacity and errorRate must match the original ones.
+func NewScalingBloomFromFile(capacity uint, errorRate float64, filename string) (*ScalingBloom, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sb := &ScalingBloom{
+		capacity:  uint64(capacity),
+		errorRate: errorRate,
+		file:      f,
+	}
+
+	if fi.Size() < scalingHeaderSize {
+		f.Close()
+		return nil, ErrBadSize
+	}
+	if err := sb.remap(int(fi.Size())); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if string(sb.data[0:8]) != string(magic[:]) {
+		sb.Close()
+		return nil, ErrBadMagic
+	}
+	if binary.LittleEndian.Uint64(sb.data[8:16]) != sb.capacity ||
+		binary.LittleEndian.Uint64(sb.data[16:24]) != math.Float64bits(errorRate) {
+		sb.Close()
+		return nil, ErrParams
+	}
+
+	offset := scalingHeaderSize
+	for offset < len(sb.data) {
+		cb := newCountingBloom(sb.capacity, sb.nextErrorRate(), offset)
+		offset += countingHeaderSize + cb.size
+		if offset > len(sb.data) {
+			sb.Close()
+			return nil, ErrBadSize
+		}
+		sb.blooms = append(sb.blooms, cb)
+	}
+	if len(sb.blooms) == 0 {
+		sb.Close()
+		return nil, ErrBadSize
+	}
+
+	return sb, nil
+}
+
+func (sb *ScalingBloom) nextErrorRate() float64 {
+	return sb.errorRate * math.Pow(errorTighteningRatio, float64(len(sb.blooms)+1))
+}
+
+// remap resizes the file and maps it again.
+func (sb *ScalingBloom) remap(size int) error {
+	if sb.data != nil {
+		if err := munmap(sb.file, sb.data); err != nil {
+			return err
+		}
+		sb.data = nil
+	}
+	if err := sb.file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	data, err := mmap(sb.file, size)
+	if err != nil {
+		return err
+	}
+	sb.data = data
+	return nil
+}
+
+// grow appends a new counting filter to the file.
+func (sb *ScalingBloom) grow() error {
+	cb := newCountingBloom(sb.capacity, sb.nextErrorRate(), len(sb.data))
+	if err := sb.remap(len(sb.data) + countingHeaderSize + cb.size); err != nil {
+		return err
+	}
+	sb.blooms = append(sb.blooms, cb)
+	return nil
+}
+
+func (sb *ScalingBloom) maxID() uint64 {
+	return binary.LittleEndian.Uint64(sb.data[24:32])
+}
+
+// MemSeqNum returns the sequence number of the last change.
+func (sb *ScalingBloom) MemSeqNum() uint64 {
+	return binary.LittleEndian.Uint64(sb.data[32:40])
+}
+
+// DiskSeqNum returns the sequence number of the last change that was
+// flushed to disk, or 0 if there are unflushed changes.
+func (sb *ScalingBloom) DiskSeqNum() uint64 {
+	return binary.LittleEndian.Uint64(sb.data[40:48])
+}
+
+// clearSeqNums marks the file as dirty and returns the current sequence
+// number.
+func (sb *ScalingBloom) clearSeqNums() uint64 {
+	seqnum := sb.DiskSeqNum()
+	if seqnum != 0 {
+		binary.LittleEndian.PutUint64(sb.data[40:48], 0)
+	} else {
+		seqnum = sb.MemSeqNum()
+	}
+	return seqnum
+}
+
+// hashKey returns the two hashes that are combined to compute the bucket
+// indexes, as in Kirsch and Mitzenmacher.
+func hashKey(key []byte) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	h1 = h.Sum64()
+	h = fnv.New64()
+	h.Write(key)
+	h2 = h.Sum64() | 1
+	return
+}
+
+// Check reports whether key might have been added to the filter.
+func (sb *ScalingBloom) Check(key []byte) bool {
+	h1, h2 := hashKey(key)
+	for i := len(sb.blooms) - 1; i >= 0; i-- {
+		cb := sb.blooms[i]
+		counters := cb.counters(sb.data)
+		found := true
+		for n := uint64(0); n < cb.hashes; n++ {
+			if getCounter(counters, (h1+n*h2)%cb.buckets) == 0 {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds key to the filter, in the newest counting filter with an id
+// not greater than id. If that is full and id is the greatest seen so
+// far, a new counting filter is appended.
+func (sb *ScalingBloom) Add(key []byte, id uint64) error {
+	var cb *countingBloom
+	for i := len(sb.blooms) - 1; i >= 0; i-- {
+		cb = sb.blooms[i]
+		if id >= binary.LittleEndian.Uint64(cb.header(sb.data)[8:16]) {
+			break
+		}
+	}
+
+	seqnum := sb.clearSeqNums()
+
+	maxID := sb.maxID()
+	if id > maxID && binary.LittleEndian.Uint64(cb.header(sb.data)[0:8]) >= sb.capacity-1 {
+		if err := sb.grow(); err != nil {
+			return err
+		}
+		cb = sb.blooms[len(sb.blooms)-1]
+		binary.LittleEndian.PutUint64(cb.header(sb.data)[8:16], maxID+1)
+	}
+	if maxID < id {
+		binary.LittleEndian.PutUint64(sb.data[24:32], id)
+	}
+
+	h1, h2 := hashKey(key)
+	counters := cb.counters(sb.data)
+	for n := uint64(0); n < cb.hashes; n++ {
+		incCounter(counters, (h1+n*h2)%cb.buckets)
+	}
+	header := cb.header(sb.data)
+	binary.LittleEndian.PutUint64(header[0:8], binary.LittleEndian.Uint64(header[0:8])+1)
+
+	binary.LittleEndian.PutUint64(sb.data[32:40], seqnum+1)
+	return nil
+}
+
+// Remove removes key, that must have been added with the same id, from
+// the filter. It returns false if no counting filter matches id.
+func (sb *ScalingBloom) Remove(key []byte, id uint64) bool {
+	for i := len(sb.blooms) - 1; i >= 0; i-- {
+		cb := sb.blooms[i]
+		header := cb.header(sb.data)
+		if id < binary.LittleEndian.Uint64(header[8:16]) {
+			continue
+		}
+
+		seqnum := sb.clearSeqNums()
+
+		h1, h2 := hashKey(key)
+		counters := cb.counters(sb.data)
+		for n := uint64(0); n < cb.hashes; n++ {
+			decCounter(counters, (h1+n*h2)%cb.buckets)
+		}
+		if count := binary.LittleEndian.Uint64(header[0:8]); count > 0 {
+			binary.LittleEndian.PutUint64(header[0:8], count-1)
+		}
+
+		binary.LittleEndian.PutUint64(sb.data[32:40], seqnum+1)
+		return true
+	}
+	return false
+}
+
+// Flush writes all the changes to disk, and then marks the file as clean.
+func (sb *ScalingBloom) Flush() error {
+	if err := msync(sb.file, sb.data); err != nil {
+		return err
+	}
+	if sb.DiskSeqNum() == 0 {
+		binary.LittleEndian.PutUint64(sb.data[40:48], sb.MemSeqNum())
+		return msync(sb.file, sb.data)
+	}
+	return nil
+}
+
+// Close unmaps and closes the file, without flushing it.
+func (sb *ScalingBloom) Close() error {
+	err := munmap(sb.file, sb.data)
+	sb.data = nil
+	if cerr := sb.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// The counters are 4 bits wide, and stick once they saturate.
+
+func getCounter(counters []byte, i uint64) byte {
+	b := counters[i/2]
+	if i%2 == 1 {
+		b >>= 4
+	}
+	return b & 0x0f
+}
+
+func setCounter(counters []byte, i uint64, v byte) {
+	if i%2 == 1 {
+		counters[i/2] = counters[i/2]&0x0f | v<<4
+	} else {
+		counters[i/2] = counters[i/2]&0xf0 | v
+	}
+}
+
+func incCounter(counters []byte, i uint64) {
+	if v := getCounter(counters, i); v < 0x0f {
+		setCounter(counters, i, v+1)
+	}
+}
+
+func decCounter(counters []byte, i uint64) {
+	if v := getCounter(counters, i); v > 0 && v < 0x0f {
+		setCounter(counters, i, v-1)
+	}
+}
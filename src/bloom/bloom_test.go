@@ -0,0 +1,223 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempFile(t *testing.T) (filename string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "bloom.bin"), func() { os.RemoveAll(dir) }
+}
+
+func TestAddCheckRemove(t *testing.T) {
+	filename, cleanup := tempFile(t)
+	defer cleanup()
+
+	sb, err := NewScalingBloom(1000, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+
+	if sb.Check([]byte("a")) {
+		t.Error("empty filter has a")
+	}
+	if err := sb.Add([]byte("a"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.Add([]byte("b"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if !sb.Check([]byte("a")) || !sb.Check([]byte("b")) {
+		t.Error("missing added keys")
+	}
+	if sb.MemSeqNum() != 2 || sb.DiskSeqNum() != 0 {
+		t.Errorf("seqnums are %v, %v after two adds", sb.MemSeqNum(), sb.DiskSeqNum())
+	}
+
+	if !sb.Remove([]byte("a"), 1) {
+		t.Fatal("Remove found no filter for id 1")
+	}
+	if sb.Check([]byte("a")) {
+		t.Error("removed key a still there")
+	}
+	if !sb.Check([]byte("b")) {
+		t.Error("Remove of a dropped b")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	filename, cleanup := tempFile(t)
+	defer cleanup()
+
+	sb, err := NewScalingBloom(1000, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := sb.Add([]byte(fmt.Sprint(i)), uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sb.DiskSeqNum() != sb.MemSeqNum() {
+		t.Errorf("DiskSeqNum is %v after Flush, MemSeqNum %v", sb.DiskSeqNum(), sb.MemSeqNum())
+	}
+	if err := sb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err = NewScalingBloomFromFile(1000, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+	for i := 0; i < 100; i++ {
+		if !sb.Check([]byte(fmt.Sprint(i))) {
+			t.Errorf("key %v lost by the round trip", i)
+		}
+	}
+	if sb.MemSeqNum() != 100 || sb.DiskSeqNum() != 100 {
+		t.Errorf("seqnums are %v, %v after reopening", sb.MemSeqNum(), sb.DiskSeqNum())
+	}
+	if sb.maxID() != 99 {
+		t.Errorf("max id is %v, want 99", sb.maxID())
+	}
+}
+
+func TestGrow(t *testing.T) {
+	filename, cleanup := tempFile(t)
+	defer cleanup()
+
+	sb, err := NewScalingBloom(10, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := sb.Add([]byte(fmt.Sprint(i)), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := len(sb.blooms)
+	if n < 10 {
+		t.Errorf("%v filters for 100 elements of capacity 10", n)
+	}
+	for i := 0; i < 100; i++ {
+		if !sb.Check([]byte(fmt.Sprint(i))) {
+			t.Errorf("key %v lost by growing", i)
+		}
+	}
+
+	// An old id goes to the filter it was added to
+	if !sb.Remove([]byte("5"), 6) {
+		t.Error("Remove found no filter for id 6")
+	}
+	if err := sb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err = NewScalingBloomFromFile(10, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+	if len(sb.blooms) != n {
+		t.Errorf("%v filters after reopening, want %v", len(sb.blooms), n)
+	}
+	if !sb.Check([]byte("99")) {
+		t.Error("key 99 lost by the round trip")
+	}
+}
+
+func TestBadFiles(t *testing.T) {
+	filename, cleanup := tempFile(t)
+	defer cleanup()
+
+	sb, err := NewScalingBloom(1000, 0.01, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewScalingBloomFromFile(2000, 0.01, filename); err != ErrParams {
+		t.Errorf("different capacity: got %v, want ErrParams", err)
+	}
+	if _, err := NewScalingBloomFromFile(1000, 0.05, filename); err != ErrParams {
+		t.Errorf("different error rate: got %v, want ErrParams", err)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filename, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScalingBloomFromFile(1000, 0.01, filename); err != ErrBadSize {
+		t.Errorf("truncated filter: got %v, want ErrBadSize", err)
+	}
+	if err := os.Truncate(filename, scalingHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScalingBloomFromFile(1000, 0.01, filename); err != ErrBadSize {
+		t.Errorf("no filters: got %v, want ErrBadSize", err)
+	}
+	if err := os.Truncate(filename, scalingHeaderSize-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScalingBloomFromFile(1000, 0.01, filename); err != ErrBadSize {
+		t.Errorf("short header: got %v, want ErrBadSize", err)
+	}
+
+	junk := make([]byte, fi.Size())
+	copy(junk, "not a bloom filter")
+	if err := ioutil.WriteFile(filename, junk, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScalingBloomFromFile(1000, 0.01, filename); err != ErrBadMagic {
+		t.Errorf("junk file: got %v, want ErrBadMagic", err)
+	}
+}
+
+func TestCounterSaturation(t *testing.T) {
+	counters := make([]byte, 2)
+	for _, i := range []uint64{1, 2} {
+		for n := 0; n < 20; n++ {
+			incCounter(counters, i)
+		}
+		if v := getCounter(counters, i); v != 0x0f {
+			t.Errorf("counter %v is %#x after 20 increments", i, v)
+		}
+
+		// A saturated counter has lost count, and sticks
+		decCounter(counters, i)
+		if v := getCounter(counters, i); v != 0x0f {
+			t.Errorf("saturated counter %v went down to %#x", i, v)
+		}
+	}
+	if getCounter(counters, 0) != 0 || getCounter(counters, 3) != 0 {
+		t.Errorf("neighbouring counters changed: % x", counters)
+	}
+
+	incCounter(counters, 0)
+	incCounter(counters, 0)
+	decCounter(counters, 0)
+	if v := getCounter(counters, 0); v != 1 {
+		t.Errorf("counter 0 is %v, want 1", v)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package bloom
+
+import (
+	"io"
+	"os"
+)
+
+// Where mmap is not available the file is read in memory, and written
+// back on msync and munmap.
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap(f *os.File, data []byte) error {
+	return msync(f, data)
+}
+
+func msync(f *os.File, data []byte) error {
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
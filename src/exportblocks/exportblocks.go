@@ -13,8 +13,9 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"rscan"
+
 	"github.com/conformal/btcdb"
-	_ "github.com/conformal/btcdb/ldb"
 	"github.com/conformal/btclog"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
@@ -24,9 +25,15 @@ import (
 type ShaHash btcwire.ShaHash
 
 type config struct {
-	DataDir  string `short:"b" long:"datadir" description:"Directory to store data"`
-	DbType   string `long:"dbtype" description:"Database backend"`
-	TestNet3 bool   `long:"testnet" description:"Use the test network"`
+	DataDir   string `short:"b" long:"datadir" description:"Directory to store data, or the Bitcoin Core block files for blkfile"`
+	DbType    string `long:"dbtype" description:"Database backend, blkfile to read the Bitcoin Core block files, or rpc to ask btcd"`
+	TestNet3  bool   `long:"testnet" description:"Use the test network"`
+	RegTest   bool   `long:"regtest" description:"Use the regression test network"`
+	RPCServer string `long:"rpcserver" description:"RPC server for --dbtype rpc"`
+	RPCUser   string `long:"rpcuser" description:"RPC username"`
+	RPCPass   string `long:"rpcpass" description:"RPC password"`
+	RPCCert   string `long:"rpccert" description:"RPC server certificate"`
+	NoTLS     bool   `long:"notls" description:"Connect to the RPC server without TLS"`
 }
 
 var (
@@ -42,8 +49,10 @@ const (
 
 func main() {
 	cfg := config{
-		DbType:  "leveldb",
-		DataDir: defaultDataDir,
+		DbType:    "leveldb",
+		DataDir:   defaultDataDir,
+		RPCServer: "localhost:8334",
+		RPCCert:   filepath.Join(btcdHomeDir, "rpc.cert"),
 	}
 	parser := flags.NewParser(&cfg, flags.Default)
 	args, err := parser.Parse()
@@ -63,30 +72,31 @@ func main() {
 	log = btclog.NewSubsystemLogger(backendLogger, "")
 	btcdb.UseLogger(log)
 
-	var testnet string
-	if cfg.TestNet3 {
-		testnet = "testnet"
-	} else {
-		testnet = "mainnet"
-	}
-
-	cfg.DataDir = filepath.Join(cfg.DataDir, testnet)
-
-	blockDbNamePrefix := "blocks"
-	dbName := blockDbNamePrefix + "_" + cfg.DbType
-	if cfg.DbType == "sqlite" {
-		dbName = dbName + ".db"
+	if cfg.TestNet3 && cfg.RegTest {
+		os.Stderr.WriteString("The testnet and regtest params can't be used together")
+		return
 	}
-	dbPath := filepath.Join(cfg.DataDir, dbName)
 
-	log.Infof("loading db %v", cfg.DbType)
-	db, err := btcdb.OpenDB(cfg.DbType, dbPath)
+	net, netDir := rscan.NetParams(cfg.TestNet3, cfg.RegTest)
+
+	log.Infof("loading %v", cfg.DbType)
+	src, err := rscan.OpenSource(&rscan.SourceConfig{
+		Type:      cfg.DbType,
+		DataDir:   cfg.DataDir,
+		NetDir:    netDir,
+		Net:       net,
+		RPCServer: cfg.RPCServer,
+		RPCUser:   cfg.RPCUser,
+		RPCPass:   cfg.RPCPass,
+		RPCCert:   cfg.RPCCert,
+		NoTLS:     cfg.NoTLS,
+	})
 	if err != nil {
-		log.Warnf("db open failed: %v", err)
+		log.Warnf("open failed: %v", err)
 		return
 	}
-	defer db.Close()
-	log.Infof("db load complete")
+	defer src.Close()
+	log.Infof("load complete")
 
 	list_file, err := os.Open(args[0])
 	if err != nil {
@@ -111,14 +121,9 @@ func main() {
 			continue
 		}
 
-		sha, err := db.FetchBlockShaByHeight(height)
-		if err != nil {
-			log.Warnf("FetchBlockShaByHeight %v failed: %v", string(list[i]), err)
-			continue
-		}
-		blk, err := db.FetchBlockBySha(sha)
+		blk, err := src.BlockByHeight(height)
 		if err != nil {
-			log.Warnf("FetchBlockBySha %v failed: %v", string(list[i]), err)
+			log.Warnf("BlockByHeight %v failed: %v", string(list[i]), err)
 			continue
 		}
 		bts, err := blk.Bytes()
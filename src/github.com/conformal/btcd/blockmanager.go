@@ -1150,6 +1150,14 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 			b.server.txMemPool.RemoveOrphan(tx.Sha())
 		}
 
+		// Add the signatures in the block to the R index.
+		if r := b.server.rIndex; r != nil {
+			if err := r.ConnectBlock(block); err != nil {
+				bmgrLog.Errorf("Failed to add block %v to the R "+
+					"index: %v", block.Height(), err)
+			}
+		}
+
 		if r := b.server.rpcServer; r != nil {
 			// Now that this block is in the blockchain we can mark
 			// all the transactions (except the coinbase) as no
@@ -1183,6 +1191,14 @@ func (b *blockManager) handleNotifyMsg(notification *btcchain.Notification) {
 			}
 		}
 
+		// Remove the signatures in the block from the R index.
+		if r := b.server.rIndex; r != nil {
+			if err := r.DisconnectBlock(block); err != nil {
+				bmgrLog.Errorf("Failed to remove block %v from the "+
+					"R index: %v", block.Height(), err)
+			}
+		}
+
 		// Notify registered websocket clients.
 		if r := b.server.rpcServer; r != nil {
 			r.ntfnMgr.NotifyBlockDisconnected(block)
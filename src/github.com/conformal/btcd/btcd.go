@@ -95,6 +95,9 @@ func btcdMain(serverChan chan<- *server) error {
 			cfg.Listeners, err)
 		return err
 	}
+	if server.rIndex != nil {
+		defer server.rIndex.Close()
+	}
 	addInterruptHandler(func() {
 		btcdLog.Infof("Gracefully shutting down the server...")
 		server.Stop()
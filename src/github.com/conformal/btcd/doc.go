@@ -65,6 +65,9 @@ Application Options:
       --nocheckpoints=     Disable built-in checkpoints.  Don't do this unless
                            you know what you're doing.
       --dbtype=            Database backend to use for the Block Chain (leveldb)
+      --rindex=            Maintain an index of the signature R values for the
+                           searchrvalue RPC, and warn about transactions
+                           reusing one
       --profile=           Enable HTTP profiling on given port -- NOTE port must
                            be between 1024 and 65536 (6060)
       --cpuprofile=        Write CPU profile to the specified file
@@ -47,6 +47,7 @@ var (
 	discLog    = btclog.Disabled
 	minrLog    = btclog.Disabled
 	peerLog    = btclog.Disabled
+	ridxLog    = btclog.Disabled
 	rpcsLog    = btclog.Disabled
 	scrpLog    = btclog.Disabled
 	srvrLog    = btclog.Disabled
@@ -63,6 +64,7 @@ var subsystemLoggers = map[string]btclog.Logger{
 	"DISC": discLog,
 	"MINR": minrLog,
 	"PEER": peerLog,
+	"RIDX": ridxLog,
 	"RPCS": rpcsLog,
 	"SCRP": scrpLog,
 	"SRVR": srvrLog,
@@ -121,6 +123,9 @@ func useLogger(subsystemID string, logger btclog.Logger) {
 	case "PEER":
 		peerLog = logger
 
+	case "RIDX":
+		ridxLog = logger
+
 	case "RPCS":
 		rpcsLog = logger
 
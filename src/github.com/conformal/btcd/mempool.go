@@ -969,9 +969,28 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *btcutil.Tx, isOrphan *bool, isNe
 	txmpLog.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
 
+	// Warn about signatures which reuse an R value already seen in the
+	// main chain, since the private key can be computed from the two.
+	var reuses []rValueReuse
+	if mp.server.rIndex != nil && isNew {
+		reuses, err = mp.server.rIndex.CheckTransaction(tx)
+		if err != nil {
+			txmpLog.Errorf("Failed to check transaction %v against the "+
+				"R index: %v", txHash, err)
+		}
+		for _, reuse := range reuses {
+			txmpLog.Warnf("Transaction %v input %d reuses R value %s, "+
+				"already used %d times in the main chain", txHash,
+				reuse.txIn, rValueHex(reuse.r), len(reuse.uses))
+		}
+	}
+
 	if mp.server.rpcServer != nil {
 		// Notify websocket clients about mempool transactions.
 		mp.server.rpcServer.ntfnMgr.NotifyMempoolTx(tx, isNew)
+		for _, reuse := range reuses {
+			mp.server.rpcServer.ntfnMgr.NotifyRValueReused(tx, reuse)
+		}
 
 		// Potentially notify any getblocktemplate long poll clients
 		// about stale block templates due to the new transaction.
@@ -0,0 +1,276 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+
+	"rscan"
+
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/conformal/btcws"
+)
+
+const (
+	// rIndexDirname is the name of the directory, inside the data
+	// directory, which holds the signature R value index.
+	rIndexDirname = "rindex"
+
+	// rIndexReorgDepth is how many blocks are indexed again when the
+	// best chain moved to another branch while btcd was not running.
+	rIndexReorgDepth = 100
+
+	// rIndexProgressInterval is how often, in blocks, the progress of
+	// the initial indexing is logged.
+	rIndexProgressInterval = 10000
+)
+
+// rValueReuse describes a signature of a transaction input which reuses an
+// R value already used on the main chain.  The uses are the index entries,
+// which are only resolved to transactions by Resolve.
+type rValueReuse struct {
+	txIn int
+	r    *big.Int
+	uses []*rscan.Signature
+}
+
+// rIndex is an optional index of the R values of all the signatures in the
+// main chain, kept in sync by the block manager.  It lets the searchrvalue
+// RPC find the earlier uses of an R value, and the memory pool warn about
+// transactions which reuse one, as that leaks the private key.
+type rIndex struct {
+	db  btcdb.Db
+	idx *rscan.Index
+}
+
+// newRIndex opens or creates the R index in the passed data directory and
+// brings it up to date with the main chain in db.  The first time the index
+// is enabled this requires going over the whole chain.
+func newRIndex(db btcdb.Db, dataDir string) (*rIndex, error) {
+	idx, err := rscan.OpenIndex(filepath.Join(dataDir, rIndexDirname))
+	if err != nil {
+		return nil, err
+	}
+
+	ri := &rIndex{db: db, idx: idx}
+	if err := ri.catchUp(); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return ri, nil
+}
+
+// catchUp indexes the blocks of the main chain after the last one in the
+// index.
+func (ri *rIndex) catchUp() error {
+	_, newest, err := ri.db.NewestSha()
+	if err != nil {
+		return err
+	}
+
+	tipSha, tipHeight, err := ri.idx.Tip()
+	if err != nil {
+		return err
+	}
+	start := int64(0)
+	if tipSha != nil {
+		start = tipHeight + 1
+
+		// The index tip must still be in the main chain, otherwise the
+		// disconnected blocks are gone and their signatures can only be
+		// removed with a pass over the whole index.
+		sha, err := ri.db.FetchBlockShaByHeight(tipHeight)
+		if err != nil || !sha.IsEqual(tipSha) {
+			start = tipHeight - rIndexReorgDepth
+			if start > newest-rIndexReorgDepth {
+				start = newest - rIndexReorgDepth
+			}
+			if start < 0 {
+				start = 0
+			}
+			ridxLog.Warnf("R index tip %v is not in the main chain, "+
+				"indexing again from height %d", tipSha, start)
+			n, err := ri.idx.DeleteFrom(start)
+			if err != nil {
+				return err
+			}
+			ridxLog.Infof("Removed %d R index entries from height %d",
+				n, start)
+		}
+	}
+	if start > newest {
+		return nil
+	}
+
+	ridxLog.Infof("Catching up the R index from height %d to %d", start,
+		newest)
+	batch := rscan.NewBatch()
+	for height := start; height <= newest; height++ {
+		sha, err := ri.db.FetchBlockShaByHeight(height)
+		if err != nil {
+			return err
+		}
+		block, err := ri.db.FetchBlockBySha(sha)
+		if err != nil {
+			return err
+		}
+		if err := addBlockToBatch(batch, block); err != nil {
+			return err
+		}
+		if batch.Len() >= rscan.IndexBatchSize {
+			if err := ri.idx.Write(batch); err != nil {
+				return err
+			}
+		}
+		if height%rIndexProgressInterval == 0 {
+			ridxLog.Infof("Indexed R values up to height %d", height)
+		}
+	}
+	if err := ri.idx.Write(batch); err != nil {
+		return err
+	}
+	ridxLog.Infof("R index is up to date")
+	return nil
+}
+
+// addBlockToBatch queues all the signatures in block to be added to the
+// index, and the block to be recorded as the index tip.
+func addBlockToBatch(batch *rscan.Batch, block *btcutil.Block) error {
+	sigs, _ := rscan.BlockSignatures(block)
+	for _, sig := range sigs {
+		batch.Add(sig)
+	}
+	sha, err := block.Sha()
+	if err != nil {
+		return err
+	}
+	batch.SetTip(sha, block.Height())
+	return nil
+}
+
+// ConnectBlock adds the signatures of a block just connected to the main
+// chain.
+func (ri *rIndex) ConnectBlock(block *btcutil.Block) error {
+	batch := rscan.NewBatch()
+	if err := addBlockToBatch(batch, block); err != nil {
+		return err
+	}
+	return ri.idx.Write(batch)
+}
+
+// DisconnectBlock removes the signatures of a block just disconnected from
+// the main chain.
+func (ri *rIndex) DisconnectBlock(block *btcutil.Block) error {
+	batch := rscan.NewBatch()
+	sigs, _ := rscan.BlockSignatures(block)
+	for _, sig := range sigs {
+		batch.Delete(sig)
+	}
+	batch.SetTip(&block.MsgBlock().Header.PrevBlock, block.Height()-1)
+	return ri.idx.Write(batch)
+}
+
+// Search returns all the uses of the R value r in the main chain.
+func (ri *rIndex) Search(r *big.Int) ([]btcws.SearchRValueResult, error) {
+	uses, err := ri.idx.Lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	return ri.Resolve(uses), nil
+}
+
+// Resolve fetches the blocks and transactions of the passed index entries.
+// Entries which don't point to a transaction of the main chain, like those
+// left by a reorg, are skipped.
+func (ri *rIndex) Resolve(uses []*rscan.Signature) []btcws.SearchRValueResult {
+	// The uses are sorted by height, so each block is fetched once.
+	results := make([]btcws.SearchRValueResult, 0, len(uses))
+	var block *btcutil.Block
+	for _, use := range uses {
+		if block == nil || block.Height() != use.H {
+			block = nil
+			sha, err := ri.db.FetchBlockShaByHeight(use.H)
+			if err == nil {
+				block, err = ri.db.FetchBlockBySha(sha)
+			}
+			if err != nil {
+				ridxLog.Warnf("R index entry at height %d points "+
+					"to a missing block: %v", use.H, err)
+				continue
+			}
+		}
+		tx, err := block.Tx(use.Tx)
+		if err != nil {
+			ridxLog.Warnf("R index entry at height %d points to a "+
+				"missing transaction: %v", use.H, err)
+			continue
+		}
+		sha, err := block.Sha()
+		if err != nil {
+			continue
+		}
+		results = append(results, btcws.SearchRValueResult{
+			Height: int32(use.H),
+			Hash:   sha.String(),
+			TxID:   tx.Sha().String(),
+			TxIn:   use.TxIn,
+			Push:   use.Data,
+		})
+	}
+	return results
+}
+
+// CheckTransaction returns the signatures of tx which reuse an R value
+// already used in the main chain.  It only reads the index, so it is cheap
+// enough to run with the memory pool locked.
+func (ri *rIndex) CheckTransaction(tx *btcutil.Tx) ([]rValueReuse, error) {
+	var reuses []rValueReuse
+	for i, txIn := range tx.MsgTx().TxIn {
+		sigs, _ := rscan.SigScriptSignatures(txIn.SignatureScript)
+		for _, sig := range sigs {
+			uses, err := ri.idx.Lookup(sig.Sig.R)
+			if err != nil {
+				return nil, err
+			}
+			if len(uses) == 0 {
+				continue
+			}
+			reuses = append(reuses, rValueReuse{
+				txIn: i,
+				r:    sig.Sig.R,
+				uses: uses,
+			})
+		}
+	}
+	return reuses, nil
+}
+
+// Close closes the underlying index.
+func (ri *rIndex) Close() error {
+	return ri.idx.Close()
+}
+
+// rValueHex returns r as the 64 character hex string used by searchrvalue.
+func rValueHex(r *big.Int) string {
+	return fmt.Sprintf("%064x", r)
+}
+
+// parseRValue parses an R value given as a hex string of up to 64
+// characters.
+func parseRValue(s string) (*big.Int, error) {
+	if len(s) == 0 || len(s) > 2*btcwire.HashSize {
+		return nil, fmt.Errorf("R value must be 1 to %d hex characters",
+			2*btcwire.HashSize)
+	}
+	r, ok := new(big.Int).SetString(s, 16)
+	if !ok || r.Sign() < 0 {
+		return nil, fmt.Errorf("R value %q is not a hex string", s)
+	}
+	return r, nil
+}
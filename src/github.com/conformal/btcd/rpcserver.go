@@ -150,6 +150,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getwork":              handleGetWork,
 	"help":                 handleHelp,
 	"ping":                 handlePing,
+	"searchrvalue":         handleSearchRValue,
 	"sendrawtransaction":   handleSendRawTransaction,
 	"setgenerate":          handleSetGenerate,
 	"stop":                 handleStop,
@@ -2938,6 +2939,36 @@ func handlePing(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handleSearchRValue implements the searchrvalue command.
+func handleSearchRValue(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcws.SearchRValueCmd)
+
+	if s.server.rIndex == nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrMisc.Code,
+			Message: "The R index is disabled, start btcd with --rindex",
+		}
+	}
+
+	r, err := parseRValue(c.R)
+	if err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInvalidParameter.Code,
+			Message: err.Error(),
+		}
+	}
+
+	uses, err := s.server.rIndex.Search(r)
+	if err != nil {
+		rpcsLog.Errorf("Error searching the R index: %v", err)
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrInternal.Code,
+			Message: err.Error(),
+		}
+	}
+	return uses, nil
+}
+
 // handleSendRawTransaction implements the sendrawtransaction command.
 func handleSendRawTransaction(s *rpcServer, cmd btcjson.Cmd, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.SendRawTransactionCmd)
@@ -226,6 +226,24 @@ func (m *wsNotificationManager) NotifyMempoolTx(tx *btcutil.Tx, isNew bool) {
 	}
 }
 
+// NotifyRValueReused passes a transaction accepted by mempool which reuses
+// an R value already in the main chain to the notification manager.
+func (m *wsNotificationManager) NotifyRValueReused(tx *btcutil.Tx, reuse rValueReuse) {
+	n := &notificationRValueReused{
+		tx:    tx,
+		reuse: reuse,
+	}
+
+	// As NotifyRValueReused will be called by mempool and the RPC server
+	// may no longer be running, use a select statement to unblock
+	// enqueueing the notification once the RPC server has begun
+	// shutting down.
+	select {
+	case m.queueNotification <- n:
+	case <-m.quit:
+	}
+}
+
 // Notification types
 type notificationBlockConnected btcutil.Block
 type notificationBlockDisconnected btcutil.Block
@@ -233,6 +251,10 @@ type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *btcutil.Tx
 }
+type notificationRValueReused struct {
+	tx    *btcutil.Tx
+	reuse rValueReuse
+}
 
 // Notification control requests
 type notificationRegisterClient wsClient
@@ -313,6 +335,12 @@ out:
 				}
 				m.notifyForTx(watchedOutPoints, watchedAddrs, n.tx, nil)
 
+			case *notificationRValueReused:
+				if len(txNotifications) != 0 {
+					m.notifyRValueReused(txNotifications, n.tx,
+						n.reuse)
+				}
+
 			case *notificationRegisterBlocks:
 				wsc := (*wsClient)(n)
 				blockNotifications[wsc.quit] = wsc
@@ -510,6 +538,27 @@ func (m *wsNotificationManager) notifyForNewTx(clients map[chan struct{}]*wsClie
 	}
 }
 
+// notifyRValueReused notifies websocket clients that have registered for
+// new mempool transactions that a transaction reuses an R value.
+func (m *wsNotificationManager) notifyRValueReused(clients map[chan struct{}]*wsClient,
+	tx *btcutil.Tx, reuse rValueReuse) {
+
+	// The earlier uses are resolved here, rather than by mempool, so the
+	// blocks are not fetched while it holds its lock.
+	uses := m.server.server.rIndex.Resolve(reuse.uses)
+	ntfn := btcws.NewRValueReusedNtfn(tx.Sha().String(), reuse.txIn,
+		rValueHex(reuse.r), uses)
+	marshalledJSON, err := json.Marshal(ntfn)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal R value reuse notification: "+
+			"%v", err)
+		return
+	}
+	for _, wsc := range clients {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
 // RegisterSpentRequest requests an notification when the passed outpoint is
 // confirmed spent (contained in a block connected to the main chain) for the
 // passed websocket client.  The request is automatically removed once the
@@ -77,6 +77,7 @@ type server struct {
 	rpcServer            *rpcServer
 	blockManager         *blockManager
 	txMemPool            *txMemPool
+	rIndex               *rIndex
 	cpuMiner             *CPUMiner
 	modifyRebroadcastInv chan interface{}
 	newPeers             chan *peer
@@ -1238,6 +1239,13 @@ func newServer(listenAddrs []string, db btcdb.Db, netParams *btcnet.Params) (*se
 	s.txMemPool = newTxMemPool(&s)
 	s.cpuMiner = newCPUMiner(&s)
 
+	if cfg.RIndex {
+		s.rIndex, err = newRIndex(db, cfg.DataDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if !cfg.DisableRPC {
 		s.rpcServer, err = newRPCServer(cfg.RPCListeners, &s)
 		if err != nil {
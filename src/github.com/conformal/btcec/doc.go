@@ -17,5 +17,10 @@ functionality is provided to parse signatures and public keys from
 standard formats.  It was designed for use with btcd, but should be
 general enough for other uses of elliptic curve crypto.  It was originally based
 on some initial work by ThePiachu, but has significantly diverged since then.
+
+Signatures made with PrivateKey.Sign and SignCompact use deterministic nonces
+as described in RFC 6979 (https://tools.ietf.org/html/rfc6979) instead of
+ones read from a random number generator, so a broken generator can't make
+two signatures share a nonce and leak the private key.
 */
 package btcec
@@ -6,7 +6,6 @@ package btcec
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"math/big"
 )
 
@@ -38,14 +37,12 @@ func (p *PrivateKey) ToECDSA() *ecdsa.PrivateKey {
 	return (*ecdsa.PrivateKey)(p)
 }
 
-// Sign wraps ecdsa.Sign to sign the provided hash (which should be the result
-// of hashing a larger message) using the private key.
+// Sign generates an ECDSA signature for the provided hash (which should be
+// the result of hashing a larger message) using the private key.  The nonce
+// is derived deterministically from the key and the hash as described in
+// RFC 6979, and the produced signature has a low S value as in BIP 62.
 func (p *PrivateKey) Sign(hash []byte) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, p.ToECDSA(), hash)
-	if err != nil {
-		return nil, err
-	}
-	return &Signature{R: r, S: s}, nil
+	return signRFC6979(p, hash)
 }
 
 // PrivKeyBytesLen defines the length in bytes of a serialized private key.
@@ -5,11 +5,14 @@
 package btcec
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"math/big"
 )
 
@@ -40,10 +43,7 @@ var (
 // 0x30 <length> 0x02 <length r> r 0x02 <length s> s
 func (sig *Signature) Serialize() []byte {
 	// low 'S' malleability breaker
-	sigS := sig.S
-	if sigS.Cmp(halforder) == 1 {
-		sigS = new(big.Int).Sub(order, sigS)
-	}
+	sigS := sig.LowS().S
 	// Ensure the encoded bytes for the r and s values are canonical and
 	// thus suitable for DER encoding.
 	rb := canonicalizeInt(sig.R)
@@ -65,6 +65,23 @@ func (sig *Signature) Serialize() []byte {
 	return b
 }
 
+// IsLowS returns whether S is in the lower half of the curve order, the only
+// form BIP 62 treats as canonical.  Since (R, N-S) is also a valid signature
+// of the same hash, anyone can replace a high S with the low one, changing
+// the id of the transaction without invalidating it.
+func (sig *Signature) IsLowS() bool {
+	return sig.S.Cmp(halforder) != 1
+}
+
+// LowS returns the signature with S in the lower half of the curve order:
+// sig itself if it already is, or a new signature with S replaced by N-S.
+func (sig *Signature) LowS() *Signature {
+	if sig.IsLowS() {
+		return sig
+	}
+	return &Signature{R: sig.R, S: new(big.Int).Sub(order, sig.S)}
+}
+
 // Verify calls ecdsa.Verify to verify the signature of hash using the public
 // key.  It returns true if the signature is valid, false otherwise.
 func (sig *Signature) Verify(hash []byte, pubKey *PublicKey) bool {
@@ -328,14 +345,14 @@ func recoverKeyFromSignature(curve *KoblitzCurve, sig *Signature, msg []byte,
 // returned in the format:
 // <(byte of 27+public key solution)+4 if compressed >< padded bytes for signature R><padded bytes for signature S>
 // where the R and S parameters are padde up to the bitlengh of the curve.
+// Like PrivateKey.Sign, the nonce is generated as described in RFC 6979.
 func SignCompact(curve *KoblitzCurve, key *ecdsa.PrivateKey,
 	hash []byte, isCompressedKey bool) ([]byte, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, key, hash)
+	sig, err := signRFC6979((*PrivateKey)(key), hash)
 	if err != nil {
 		return nil, err
 	}
 
-	sig := &Signature{R: r, S: s}
 	// bitcoind checks the bit length of R and S here. The ecdsa signature
 	// algorithm returns R and S mod N therefore they will be the bitsize of
 	// the curve, and thus correctly sized.
@@ -398,3 +415,123 @@ func RecoverCompact(curve *KoblitzCurve, signature,
 
 	return key, ((signature[0] - 27) & 4) == 4, nil
 }
+
+// signRFC6979 generates a deterministic ECDSA signature of hash, with the
+// nonce from RFC 6979 and S in the lower half of the order as BIP 62
+// asks.  The same key and hash always give the same signature, so a
+// broken random number generator can't leak the key by reusing a nonce.
+func signRFC6979(privateKey *PrivateKey, hash []byte) (*Signature, error) {
+	privkey := privateKey.ToECDSA()
+	N := order
+	k := nonceRFC6979(privkey.D, hash)
+	inv := new(big.Int).ModInverse(k, N)
+	r, _ := privkey.Curve.ScalarBaseMult(k.Bytes())
+	r.Mod(r, N)
+	if r.Sign() == 0 {
+		return nil, errors.New("calculated R is zero")
+	}
+
+	e := hashToInt(hash, privkey.Curve)
+	s := new(big.Int).Mul(privkey.D, r)
+	s.Add(s, e)
+	s.Mul(s, inv)
+	s.Mod(s, N)
+	if s.Cmp(halforder) == 1 {
+		s.Sub(N, s)
+	}
+	if s.Sign() == 0 {
+		return nil, errors.New("calculated S is zero")
+	}
+	return &Signature{R: r, S: s}, nil
+}
+
+// nonceRFC6979 generates the ECDSA nonce k for the private key and hash
+// deterministically, as described in section 3.2 of RFC 6979, with
+// HMAC-SHA256.
+func nonceRFC6979(privkey *big.Int, hash []byte) *big.Int {
+	curve := S256()
+	q := curve.Params().N
+	qlen := q.BitLen()
+	rolen := (qlen + 7) >> 3
+	holen := sha256.Size
+
+	bx := append(int2octets(privkey, rolen), bits2octets(hash, curve, rolen)...)
+
+	// Step B
+	v := bytes.Repeat([]byte{0x01}, holen)
+
+	// Step C
+	k := make([]byte, holen)
+
+	// Step D
+	k = mac(sha256.New, k, v, []byte{0x00}, bx)
+
+	// Step E
+	v = mac(sha256.New, k, v)
+
+	// Step F
+	k = mac(sha256.New, k, v, []byte{0x01}, bx)
+
+	// Step G
+	v = mac(sha256.New, k, v)
+
+	// Step H
+	for {
+		// Step H1 and H2
+		var t []byte
+		for len(t)*8 < qlen {
+			v = mac(sha256.New, k, v)
+			t = append(t, v...)
+		}
+
+		// Step H3
+		secret := hashToInt(t, curve)
+		if secret.Sign() > 0 && secret.Cmp(q) < 0 {
+			return secret
+		}
+		k = mac(sha256.New, k, v, []byte{0x00})
+		v = mac(sha256.New, k, v)
+	}
+}
+
+// mac returns the HMAC of the concatenation of data with key k.
+func mac(alg func() hash.Hash, k []byte, data ...[]byte) []byte {
+	h := hmac.New(alg, k)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// int2octets is the int2octets function of section 2.3.3 of RFC 6979:
+// v as a big-endian number of rolen bytes.
+func int2octets(v *big.Int, rolen int) []byte {
+	out := v.Bytes()
+
+	// left pad with zeros if it's too short
+	if len(out) < rolen {
+		out2 := make([]byte, rolen)
+		copy(out2[rolen-len(out):], out)
+		return out2
+	}
+
+	// drop most significant bytes if it's too long
+	if len(out) > rolen {
+		out2 := make([]byte, rolen)
+		copy(out2, out[len(out)-rolen:])
+		return out2
+	}
+
+	return out
+}
+
+// bits2octets is the bits2octets function of section 2.3.4 of RFC 6979:
+// the hash as a number reduced modulo the curve order, of rolen bytes.
+func bits2octets(in []byte, curve elliptic.Curve, rolen int) []byte {
+	z1 := hashToInt(in, curve)
+	z2 := new(big.Int).Sub(z1, curve.Params().N)
+	if z2.Sign() < 0 {
+		return int2octets(z1, rolen)
+	}
+	return int2octets(z2, rolen)
+}
@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
@@ -491,3 +493,117 @@ func TestSignCompact(t *testing.T) {
 		testSignCompact(t, name, btcec.S256(), data, compressed)
 	}
 }
+
+func TestRFC6979(t *testing.T) {
+	// The secp256k1 and SHA-256 test vectors used by the other Bitcoin
+	// implementations of RFC 6979, with S in the lower half of the order.
+	tests := []struct {
+		key       string
+		msg       string
+		nonce     string
+		signature string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"Satoshi Nakamoto",
+			"8f8a276c19f4149656b280621e358cce24f5f52542772691ee69063b74f15d15",
+			"934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8" +
+				"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5",
+		},
+		{
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"All those moments will be lost in time, like tears in rain. Time to die...",
+			"38aa22d72376b4dbc472e06c3ba403ee0a394da63fc58d88686c611aba98d6b3",
+			"8600dbd41e348fe5c9465ab92d23e3db8b98b873beecd930736488696438cb6b" +
+				"547fe64427496db33bf66019dacbf0039c04199abb0122918601db38a72cfc21",
+		},
+		{
+			"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140",
+			"Satoshi Nakamoto",
+			"33a19b60e25fb6f4435af53a3d42d493644827367e6453928554f43e49aa6f90",
+			"fd567d121db66e382991534ada77a6bd3106f0a1098c231e47993447cd6af2d0" +
+				"6b39cd0eb1bc8603e159ef5c20a5c8ad685a45b06ce9bebed3f153d10d93bed5",
+		},
+		{
+			"f8b8af8ce3c7cca5e300d33939540c10d45ce001b8f252bfbc57ba0342904181",
+			"Alan Turing",
+			"525a82b70e67874398067543fd84c83d30c175fdc45fdeee082fe13b1d7cfdf1",
+			"7063ae83e7f62bbb171798131b4a0564b956930092b33b07b395615d9ec7e15c" +
+				"58dfcc1e00a35e1572f366ffe34ba0fc47db1e7189759b9fb233c5b05ab388ea",
+		},
+		{
+			"e91671c46231f833a6406ccbea0e3e392c76c167bac1cb013f6f1013980455c2",
+			"There is a computer disease that anybody who works with computers knows about. It's a very serious disease and it interferes completely with the work. The trouble with computers is that you 'play' with them!",
+			"1f4b84c23a86a221d233f2521be018d9318639d5b8bbd6374a8a59232d16ad3d",
+			"b552edd27580141f3b2a5463048cb7cd3e047b97c9f98076c32dbdf85a68718b" +
+				"279fa72dd19bfae05577e06c7c0c1900c371fcd5893f7e1d56a37d30174671f6",
+		},
+	}
+
+	for i, test := range tests {
+		keyBytes, _ := hex.DecodeString(test.key)
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+		hash := sha256.Sum256([]byte(test.msg))
+
+		nonce := btcec.TstNonceRFC6979(privKey.D, hash[:])
+		if got := fmt.Sprintf("%064x", nonce); got != test.nonce {
+			t.Errorf("test %d: nonce %v, want %v", i, got, test.nonce)
+		}
+
+		sig, err := privKey.Sign(hash[:])
+		if err != nil {
+			t.Errorf("test %d: Sign error: %v", i, err)
+			continue
+		}
+		if got := fmt.Sprintf("%064x%064x", sig.R, sig.S); got != test.signature {
+			t.Errorf("test %d: signature %v, want %v", i, got, test.signature)
+		}
+		if !sig.Verify(hash[:], pubKey) {
+			t.Errorf("test %d: signature doesn't verify", i)
+		}
+
+		// SignCompact uses the same nonce
+		compact, err := btcec.SignCompact(btcec.S256(), privKey.ToECDSA(), hash[:], true)
+		if err != nil {
+			t.Errorf("test %d: SignCompact error: %v", i, err)
+			continue
+		}
+		if got := hex.EncodeToString(compact[1:]); got != test.signature {
+			t.Errorf("test %d: compact signature %v, want %v", i, got, test.signature)
+		}
+	}
+}
+
+func TestSignatureLowS(t *testing.T) {
+	N := btcec.S256().N
+	half := new(big.Int).Rsh(N, 1)
+	one := big.NewInt(1)
+	tests := []struct {
+		s    *big.Int
+		low  bool
+		want *big.Int
+	}{
+		{one, true, one},
+		{half, true, half},
+		{new(big.Int).Add(half, one), false, half},
+		{new(big.Int).Sub(N, one), false, one},
+	}
+
+	for i, test := range tests {
+		sig := &btcec.Signature{R: big.NewInt(42), S: test.s}
+		if sig.IsLowS() != test.low {
+			t.Errorf("test %d: IsLowS = %v, want %v", i, !test.low, test.low)
+		}
+		low := sig.LowS()
+		if low.R.Cmp(sig.R) != 0 || low.S.Cmp(test.want) != 0 || !low.IsLowS() {
+			t.Errorf("test %d: LowS S = %x, want %x", i, low.S, test.want)
+		}
+		if sig.S != test.s {
+			t.Errorf("test %d: LowS modified the signature", i)
+		}
+		parsed, err := btcec.ParseDERSignature(sig.Serialize(), btcec.S256())
+		if err != nil || parsed.S.Cmp(test.want) != 0 {
+			t.Errorf("test %d: Serialize didn't write the low S", i)
+		}
+	}
+}
@@ -7,11 +7,9 @@ package btcscript
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/conformal/btcec"
@@ -1096,7 +1094,9 @@ func MultiSigScript(pubkeys []*btcutil.AddressPubKey, nrequired int) ([]byte, er
 // of the previous output being used as the idx'th input. privKey is
 // serialized in either a compressed or uncompressed format based on
 // compress. This format must match the same format used to generate
-// the payment address, or the script validation will fail.
+// the payment address, or the script validation will fail. The signature
+// nonce is derived from privKey and the signature hash as described in
+// RFC 6979, so signing the same input again gives the same script.
 func SignatureScript(tx *btcwire.MsgTx, idx int, subscript []byte, hashType byte, privKey *ecdsa.PrivateKey, compress bool) ([]byte, error) {
 	sig, err := signTxOutput(tx, idx, subscript, hashType, privKey)
 	if err != nil {
@@ -1117,23 +1117,17 @@ func SignatureScript(tx *btcwire.MsgTx, idx int, subscript []byte, hashType byte
 func signTxOutput(tx *btcwire.MsgTx, idx int, subScript []byte, hashType byte,
 	key *ecdsa.PrivateKey) ([]byte, error) {
 
-	return signTxOutputCustomReader(rand.Reader, tx, idx, subScript,
-		hashType, key)
-}
-
-func signTxOutputCustomReader(reader io.Reader, tx *btcwire.MsgTx, idx int,
-	subScript []byte, hashType byte, key *ecdsa.PrivateKey) ([]byte, error) {
 	parsedScript, err := parseScript(subScript)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse output script: %v", err)
 	}
 	hash := calcScriptHash(parsedScript, hashType, tx, idx)
-	r, s, err := ecdsa.Sign(reader, key, hash)
+	signature, err := (*btcec.PrivateKey)(key).Sign(hash)
 	if err != nil {
 		return nil, fmt.Errorf("cannot sign tx input: %s", err)
 	}
 
-	return append((&btcec.Signature{R: r, S: s}).Serialize(), hashType), nil
+	return append(signature.Serialize(), hashType), nil
 }
 
 func p2pkSignatureScript(tx *btcwire.MsgTx, idx int, subScript []byte, hashType byte, privKey *ecdsa.PrivateKey) ([]byte, error) {
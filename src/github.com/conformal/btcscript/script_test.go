@@ -2857,6 +2857,34 @@ nexttest:
 	}
 }
 
+// Test that signing the same input twice gives the same sigscript, as the
+// nonce comes from RFC 6979 and not from a random number generator, and
+// that a different hash type gives a different signature, not just a
+// different hash type byte.
+func TestSignatureScriptDeterministic(t *testing.T) {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyD)
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(coinbaseOutPoint, nil))
+	tx.AddTxOut(btcwire.NewTxOut(500, []byte{btcscript.OP_RETURN}))
+
+	sign := func(hashType byte) []byte {
+		script, err := btcscript.SignatureScript(tx, 0, uncompressedPkScript,
+			hashType, privKey.ToECDSA(), false)
+		if err != nil {
+			t.Fatalf("SignatureScript failed: %v", err)
+		}
+		return script
+	}
+	a, b := sign(btcscript.SigHashAll), sign(btcscript.SigHashAll)
+	if !bytes.Equal(a, b) {
+		t.Errorf("signing twice gave different scripts: %x and %x", a, b)
+	}
+	if c := sign(btcscript.SigHashNone); bytes.Equal(a[:len(a)-67], c[:len(c)-67]) {
+		t.Errorf("a different hash type gave the same signature: %x", a)
+	}
+}
+
 var classStringifyTests = []struct {
 	name        string
 	scriptclass btcscript.ScriptClass
@@ -18,6 +18,10 @@ const (
 Authenticate the websocket with the RPC server.  This is only required if the
 credentials were not already supplied via HTTP auth headers.  It must be the
 first command sent or you will be disconnected.`
+
+	searchRValueHelp = `searchrvalue "r"
+Return all the uses on the main chain of the signature R value r, a 64
+character hex string.  Requires btcd to run with --rindex.`
 )
 
 func init() {
@@ -50,6 +54,8 @@ func init() {
 		nil, `TODO(jrick) fillmein`)
 	btcjson.RegisterCustomCmd("rescan", parseRescanCmd,
 		nil, `TODO(jrick) fillmein`)
+	btcjson.RegisterCustomCmd("searchrvalue", parseSearchRValueCmd,
+		parseSearchRValueCmdReply, searchRValueHelp)
 	btcjson.RegisterCustomCmd("walletislocked", parseWalletIsLockedCmd,
 		nil, `TODO(jrick) fillmein`)
 }
@@ -519,6 +525,104 @@ func (cmd *GetBestBlockCmd) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// SearchRValueResult describes a use of a signature R value, as returned
+// by searchrvalue and sent with rvaluereused notifications.
+type SearchRValueResult struct {
+	Height int32  `json:"height"`
+	Hash   string `json:"hash"`
+	TxID   string `json:"txid"`
+	TxIn   int    `json:"txin"`
+	Push   int    `json:"push"`
+}
+
+// SearchRValueCmd is a type handling custom marshaling and
+// unmarshaling of searchrvalue JSON websocket extension
+// commands.
+type SearchRValueCmd struct {
+	id interface{}
+	R  string
+}
+
+// Enforce that SearchRValueCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &SearchRValueCmd{}
+
+// NewSearchRValueCmd creates a new SearchRValueCmd.
+func NewSearchRValueCmd(id interface{}, r string) *SearchRValueCmd {
+	return &SearchRValueCmd{
+		id: id,
+		R:  r,
+	}
+}
+
+// parseSearchRValueCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom
+// command with the btcjson parser.
+func parseSearchRValueCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var rValue string
+	if err := json.Unmarshal(r.Params[0], &rValue); err != nil {
+		return nil, errors.New("first parameter 'r' must be a " +
+			"string: " + err.Error())
+	}
+
+	return NewSearchRValueCmd(r.Id, rValue), nil
+}
+
+// parseSearchRValueCmdReply parses a the reply to a SearchRValueCmd into
+// a concrete type and returns it packed into an interface.  This is used
+// when registering the custom command with btcjson.
+func parseSearchRValueCmdReply(message json.RawMessage) (interface{}, error) {
+	var res []SearchRValueResult
+	if err := json.Unmarshal(message, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *SearchRValueCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *SearchRValueCmd) Method() string {
+	return "searchrvalue"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *SearchRValueCmd) MarshalJSON() ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(cmd.id, cmd.Method(), []interface{}{cmd.R})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *SearchRValueCmd) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newCmd, err := parseSearchRValueCmd(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteCmd, ok := newCmd.(*SearchRValueCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
 // RecoverAddressesCmd is a type handling custom marshaling and
 // unmarshaling of recoveraddresses JSON websocket extension
 // commands.
@@ -39,6 +39,17 @@ var cmdtests = []struct {
 			id: float64(1),
 		},
 	},
+	{
+		name: "searchrvalue",
+		f: func() (btcjson.Cmd, error) {
+			return NewSearchRValueCmd(float64(1),
+				"415d61093562a393da7c88c3a09ac675f5cea76d8feb111bc07af4d437b0bba3"), nil
+		},
+		result: &SearchRValueCmd{
+			id: float64(1),
+			R:  "415d61093562a393da7c88c3a09ac675f5cea76d8feb111bc07af4d437b0bba3",
+		},
+	},
 	{
 		name: "getcurrentnet",
 		f: func() (btcjson.Cmd, error) {
@@ -62,6 +62,10 @@ const (
 	// notification.
 	RescanProgressNtfnMethod = "rescanprogress"
 
+	// RValueReusedNtfnMethod is the method of the btcd rvaluereused
+	// notification.
+	RValueReusedNtfnMethod = "rvaluereused"
+
 	// WalletLockStateNtfnMethod is the method of the btcwallet
 	// walletlockstate notification.
 	WalletLockStateNtfnMethod = "walletlockstate"
@@ -93,6 +97,8 @@ func init() {
 		`TODO(flam) fillmein`)
 	btcjson.RegisterCustomCmd(TxAcceptedVerboseNtfnMethod,
 		parseTxAcceptedVerboseNtfn, nil, `TODO(flam) fillmein`)
+	btcjson.RegisterCustomCmd(RValueReusedNtfnMethod,
+		parseRValueReusedNtfn, nil, `TODO(flam) fillmein`)
 }
 
 // BlockDetails describes details of a tx in a block.
@@ -1247,3 +1253,120 @@ func (n *TxAcceptedVerboseNtfn) UnmarshalJSON(b []byte) error {
 	*n = *concreteNtfn
 	return nil
 }
+
+// RValueReusedNtfn is a type handling custom marshaling and
+// unmarshaling of rvaluereused JSON websocket notifications, sent when a
+// transaction accepted to the mempool has a signature reusing the R value
+// of a signature on the main chain.
+type RValueReusedNtfn struct {
+	TxID string
+	TxIn int
+	R    string
+	Uses []SearchRValueResult
+}
+
+// Enforce that RValueReusedNtfn satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &RValueReusedNtfn{}
+
+// NewRValueReusedNtfn creates a new RValueReusedNtfn.
+func NewRValueReusedNtfn(txid string, txIn int, r string,
+	uses []SearchRValueResult) *RValueReusedNtfn {
+
+	return &RValueReusedNtfn{
+		TxID: txid,
+		TxIn: txIn,
+		R:    r,
+		Uses: uses,
+	}
+}
+
+// parseRValueReusedNtfn parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the notification
+// with the btcjson parser.
+func parseRValueReusedNtfn(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if r.Id != nil {
+		return nil, ErrNotANtfn
+	}
+
+	if len(r.Params) != 4 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var txid string
+	if err := json.Unmarshal(r.Params[0], &txid); err != nil {
+		return nil, errors.New("first parameter 'txid' must be a " +
+			"string: " + err.Error())
+	}
+
+	var txIn int
+	if err := json.Unmarshal(r.Params[1], &txIn); err != nil {
+		return nil, errors.New("second parameter 'txin' must be an " +
+			"integer: " + err.Error())
+	}
+
+	var rValue string
+	if err := json.Unmarshal(r.Params[2], &rValue); err != nil {
+		return nil, errors.New("third parameter 'r' must be a " +
+			"string: " + err.Error())
+	}
+
+	var uses []SearchRValueResult
+	if err := json.Unmarshal(r.Params[3], &uses); err != nil {
+		return nil, errors.New("fourth parameter 'uses' must be an " +
+			"array of uses: " + err.Error())
+	}
+
+	return NewRValueReusedNtfn(txid, txIn, rValue, uses), nil
+}
+
+// Id satisifies the btcjson.Cmd interface by returning nil for a
+// notification ID.
+func (n *RValueReusedNtfn) Id() interface{} {
+	return nil
+}
+
+// Method satisifies the btcjson.Cmd interface by returning the method
+// of the notification.
+func (n *RValueReusedNtfn) Method() string {
+	return RValueReusedNtfnMethod
+}
+
+// MarshalJSON returns the JSON encoding of n.  Part of the btcjson.Cmd
+// interface.
+func (n *RValueReusedNtfn) MarshalJSON() ([]byte, error) {
+	params := []interface{}{
+		n.TxID,
+		n.TxIn,
+		n.R,
+		n.Uses,
+	}
+
+	// No ID for notifications.
+	raw, err := btcjson.NewRawCmd(nil, n.Method(), params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of n into n.  Part of
+// the btcjson.Cmd interface.
+func (n *RValueReusedNtfn) UnmarshalJSON(b []byte) error {
+	// Unmarshal into a RawCmd.
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	newNtfn, err := parseRValueReusedNtfn(&r)
+	if err != nil {
+		return err
+	}
+
+	concreteNtfn, ok := newNtfn.(*RValueReusedNtfn)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*n = *concreteNtfn
+	return nil
+}
@@ -217,6 +217,30 @@ var ntfntests = []struct {
 			Amount: 34567765,
 		},
 	},
+	{
+		name: "rvaluereused",
+		f: func() btcjson.Cmd {
+			return btcws.NewRValueReusedNtfn(
+				"9ea2976fe29fbe01d7d21180e2d9c0d4e8a17cbe0b16bd76417bebf2f81d76e2",
+				0,
+				"415d61093562a393da7c88c3a09ac675f5cea76d8feb111bc07af4d437b0bba3",
+				[]btcws.SearchRValueResult{{
+					Height: 15,
+					Hash:   "11b6916f251e666314f781e30d00dd0b793127efb0e6aa282237e6a0cc23faab",
+					TxID:   "45dee4e5811cc890023e827493c896c2fbc53d8ef31a037234d957270c996b17",
+				}})
+		},
+		result: &btcws.RValueReusedNtfn{
+			TxID: "9ea2976fe29fbe01d7d21180e2d9c0d4e8a17cbe0b16bd76417bebf2f81d76e2",
+			TxIn: 0,
+			R:    "415d61093562a393da7c88c3a09ac675f5cea76d8feb111bc07af4d437b0bba3",
+			Uses: []btcws.SearchRValueResult{{
+				Height: 15,
+				Hash:   "11b6916f251e666314f781e30d00dd0b793127efb0e6aa282237e6a0cc23faab",
+				TxID:   "45dee4e5811cc890023e827493c896c2fbc53d8ef31a037234d957270c996b17",
+			}},
+		},
+	},
 	{
 		name: "txacceptedverbose",
 		f: func() btcjson.Cmd {
@@ -0,0 +1,206 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// UnknownWallet is the family of the signatures that match no rule.
+const UnknownWallet = "unknown"
+
+// The orderings of the inputs and outputs of a transaction.
+const (
+	OrderTrivial  = "trivial"  // a single input and output, nothing to sort
+	OrderSorted   = "sorted"   // the BIP 69 order
+	OrderUnsorted = "unsorted" // any other order
+)
+
+// Features are the traits of a signature, and of the transaction it is
+// in, that tell apart the software that made them.
+type Features struct {
+	HashType byte
+
+	// StrictDER is set if the signature has no BER leftovers, like
+	// padding; LowS if S is in the lower half of the curve order
+	StrictDER bool
+	LowS      bool
+
+	// HasPubKey is set if the sigScript has the pubkey, and Compressed
+	// tells its encoding
+	HasPubKey  bool
+	Compressed bool
+
+	Version  int32
+	LockTime uint32
+	Sequence uint32
+	Ordering string
+
+	// Fee is -1 if the previous outputs couldn't be fetched
+	Fee int64
+}
+
+// Fingerprint returns the Features of the signature pushed at push in the
+// input txIn of tx. fee is the fee of tx, or -1 if unknown.
+func Fingerprint(tx *btcwire.MsgTx, txIn, push int, fee int64) (*Features, error) {
+	if txIn >= len(tx.TxIn) {
+		return nil, fmt.Errorf("no input %v", txIn)
+	}
+	sigScript := tx.TxIn[txIn].SignatureScript
+
+	var sig *Signature
+	sigs, _ := SigScriptSignatures(sigScript)
+	for _, s := range sigs {
+		if s.Data == push {
+			sig = s
+		}
+	}
+	if sig == nil {
+		return nil, fmt.Errorf("input %v: no signature at push %v", txIn, push)
+	}
+	// SigScriptSignatures already parsed the script
+	pushes, _ := btcscript.PushedData(sigScript)
+	data := pushes[push]
+
+	f := &Features{
+		HashType:  sig.HashType,
+		LowS:      sig.Sig.IsLowS(),
+		HasPubKey: sig.PubKey != nil,
+		Version:   tx.Version,
+		LockTime:  tx.LockTime,
+		Sequence:  tx.TxIn[txIn].Sequence,
+		Ordering:  txOrdering(tx),
+		Fee:       fee,
+	}
+	_, err := btcec.ParseDERSignature(data[:len(data)-1], btcec.S256())
+	f.StrictDER = err == nil
+	if f.HasPubKey {
+		f.Compressed = len(sig.PubKey) == btcec.PubKeyBytesLenCompressed
+	}
+	return f, nil
+}
+
+// TxFee returns the fee paid by tx, fetching its previous outputs from
+// src.
+func TxFee(src Source, tx *btcwire.MsgTx) (int64, error) {
+	var in, out int64
+	for _, txIn := range tx.TxIn {
+		prev := txIn.PreviousOutPoint
+		txPrev, _, err := src.TxBySha(&prev.Hash)
+		if err != nil {
+			return 0, err
+		}
+		if int(prev.Index) >= len(txPrev.TxOut) {
+			return 0, fmt.Errorf("%v has no output %v", prev.Hash, prev.Index)
+		}
+		in += txPrev.TxOut[prev.Index].Value
+	}
+	for _, txOut := range tx.TxOut {
+		out += txOut.Value
+	}
+	return in - out, nil
+}
+
+// txOrdering tells if the inputs and outputs of tx are sorted as BIP 69
+// says: inputs by previous hash, in the byte order it is shown in, and
+// index; outputs by value and pkScript.
+func txOrdering(tx *btcwire.MsgTx) string {
+	if len(tx.TxIn) < 2 && len(tx.TxOut) < 2 {
+		return OrderTrivial
+	}
+	inSorted, outSorted := true, true
+	for i := 1; i < len(tx.TxIn); i++ {
+		a, b := tx.TxIn[i-1].PreviousOutPoint, tx.TxIn[i].PreviousOutPoint
+		c := bytes.Compare(reversed(a.Hash[:]), reversed(b.Hash[:]))
+		if c > 0 || c == 0 && a.Index > b.Index {
+			inSorted = false
+		}
+	}
+	for i := 1; i < len(tx.TxOut); i++ {
+		a, b := tx.TxOut[i-1], tx.TxOut[i]
+		if a.Value > b.Value || a.Value == b.Value && bytes.Compare(a.PkScript, b.PkScript) > 0 {
+			outSorted = false
+		}
+	}
+	if inSorted && outSorted {
+		return OrderSorted
+	}
+	return OrderUnsorted
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+// walletRule labels the signatures it matches with a wallet family.
+type walletRule struct {
+	family string
+	match  func(f *Features) bool
+}
+
+// walletRules are tried in order, and the first match wins. They are
+// heuristics drawn from the defaults of the wallets of the time, and only
+// tell a likely family.
+var walletRules = []walletRule{
+	// Nothing mainstream made BER signatures or unusual sighash types
+	{"custom", func(f *Features) bool {
+		return !f.StrictDER || f.HashType != byte(btcscript.SigHashAll)
+	}},
+	// Anti fee sniping: the locktime is set to the height, and the
+	// sequence is the highest that doesn't disable it
+	{"bitcoin-core", func(f *Features) bool {
+		return f.LockTime != 0 && f.Sequence == btcwire.MaxTxInSequenceNum-1
+	}},
+	{"electrum", func(f *Features) bool {
+		return f.Ordering == OrderSorted && f.Compressed
+	}},
+	{"bitcoinj", func(f *Features) bool {
+		return f.Compressed && f.LowS && f.LockTime == 0 &&
+			f.Sequence == btcwire.MaxTxInSequenceNum
+	}},
+	// Uncompressed keys, and a flat fee of 0.0001 BTC
+	{"blockchain.info", func(f *Features) bool {
+		return f.HasPubKey && !f.Compressed && f.LockTime == 0 &&
+			f.Sequence == btcwire.MaxTxInSequenceNum && f.Fee > 0 && f.Fee%10000 == 0
+	}},
+}
+
+// Wallet returns the likely wallet family of f, or UnknownWallet.
+func (f *Features) Wallet() string {
+	for _, rule := range walletRules {
+		if rule.match(f) {
+			return rule.family
+		}
+	}
+	return UnknownWallet
+}
+
+// LikelyWallet returns the most common family in wallets, the labels of
+// the signatures of a cluster, preferring any family to UnknownWallet and
+// breaking ties by name.
+func LikelyWallet(wallets []string) string {
+	counts := make(map[string]int)
+	for _, w := range wallets {
+		if w != UnknownWallet && w != "" {
+			counts[w]++
+		}
+	}
+	best := UnknownWallet
+	for w, n := range counts {
+		if n > counts[best] || n == counts[best] && w < best {
+			best = w
+		}
+	}
+	return best
+}
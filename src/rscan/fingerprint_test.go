@@ -0,0 +1,122 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// testDER encodes R and S without any normalization, and with the
+// integers padded to pad bytes if longer than needed.
+func testDER(R, S *big.Int, pad int) []byte {
+	encode := func(n *big.Int) []byte {
+		b := n.Bytes()
+		if b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		for len(b) < pad {
+			b = append([]byte{0}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+	r, s := encode(R), encode(S)
+	return append(append([]byte{0x30, byte(len(r) + len(s))}, r...), s...)
+}
+
+func TestFingerprint(t *testing.T) {
+	a := testKey("a")
+	v := signWithNonce(a, "a", big.NewInt(42))
+	N := btcec.S256().N
+	lowS := v.Signature.LowS().S
+	highS := new(big.Int).Sub(N, lowS)
+	all := byte(btcscript.SigHashAll)
+	sig := append(testDER(v.Signature.R, lowS, 0), all)
+	sigHigh := append(testDER(v.Signature.R, highS, 0), all)
+	sigPadded := append(testDER(v.Signature.R, lowS, 34), all)
+	sigSingle := append(testDER(v.Signature.R, lowS, 0), byte(btcscript.SigHashSingle))
+	pkC := testPubKey(a).SerializeCompressed()
+	pkU := testPubKey(a).SerializeUncompressed()
+
+	// spend returns a transaction with the sigScript in its first input,
+	// and inputs and outputs in the given order
+	spend := func(sigScript []byte, sorted bool, lockTime, sequence uint32) *btcwire.MsgTx {
+		tx := btcwire.NewMsgTx()
+		prevs := []btcwire.ShaHash{{31: 1}, {31: 2}}
+		values := []int64{1e8, 2e8}
+		if !sorted {
+			prevs[0], prevs[1] = prevs[1], prevs[0]
+			values[0], values[1] = values[1], values[0]
+		}
+		for i := range prevs {
+			txIn := btcwire.NewTxIn(btcwire.NewOutPoint(&prevs[i], 0), nil)
+			txIn.Sequence = sequence
+			tx.AddTxIn(txIn)
+			tx.AddTxOut(btcwire.NewTxOut(values[i], []byte{btcscript.OP_TRUE}))
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+		tx.LockTime = lockTime
+		return tx
+	}
+	final := btcwire.MaxTxInSequenceNum
+
+	tests := []struct {
+		name   string
+		tx     *btcwire.MsgTx
+		fee    int64
+		wallet string
+	}{
+		{"padded", spend(testSigScript(sigPadded, pkC), true, 0, final), -1, "custom"},
+		{"sighash single", spend(testSigScript(sigSingle, pkC), false, 0, final), -1, "custom"},
+		{"anti fee sniping", spend(testSigScript(sig, pkU), false, 300000, final-1), 10000, "bitcoin-core"},
+		{"bip69", spend(testSigScript(sig, pkC), true, 0, final), -1, "electrum"},
+		{"low S", spend(testSigScript(sig, pkC), false, 0, final), -1, "bitcoinj"},
+		{"flat fee", spend(testSigScript(sigHigh, pkU), false, 0, final), 10000, "blockchain.info"},
+		{"fee unknown", spend(testSigScript(sigHigh, pkU), false, 0, final), -1, UnknownWallet},
+		{"high S", spend(testSigScript(sigHigh, pkC), false, 0, final), -1, UnknownWallet},
+		{"pubkey", spend(testSigScript(sig), false, 0, final), 10000, UnknownWallet},
+	}
+
+	for _, test := range tests {
+		f, err := Fingerprint(test.tx, 0, 0, test.fee)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if w := f.Wallet(); w != test.wallet {
+			t.Errorf("%v: wallet %v, want %v (%+v)", test.name, w, test.wallet, f)
+		}
+	}
+
+	f, _ := Fingerprint(spend(testSigScript(sigHigh, pkU), true, 0, final), 0, 0, -1)
+	if f.LowS || f.Compressed || !f.HasPubKey || !f.StrictDER || f.Ordering != OrderSorted {
+		t.Errorf("bad features %+v", f)
+	}
+	if _, err := Fingerprint(spend(testSigScript(sig, pkC), true, 0, final), 0, 1, -1); err == nil {
+		t.Error("no error fingerprinting a pubkey")
+	}
+}
+
+func TestLikelyWallet(t *testing.T) {
+	tests := []struct {
+		wallets []string
+		want    string
+	}{
+		{nil, UnknownWallet},
+		{[]string{UnknownWallet, UnknownWallet}, UnknownWallet},
+		{[]string{UnknownWallet, UnknownWallet, "bitcoinj"}, "bitcoinj"},
+		{[]string{"electrum", "bitcoinj", "electrum"}, "electrum"},
+		{[]string{"electrum", "bitcoinj"}, "bitcoinj"},
+	}
+	for _, test := range tests {
+		if got := LikelyWallet(test.wallets); got != test.want {
+			t.Errorf("LikelyWallet(%v) = %v, want %v", test.wallets, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/conformal/btcwire"
+	"github.com/conformal/goleveldb/leveldb"
+	"github.com/conformal/goleveldb/leveldb/opt"
+	"github.com/conformal/goleveldb/leveldb/util"
+)
+
+const (
+	// IndexBatchSize is the suggested number of signatures per Batch.
+	IndexBatchSize = 10000
+
+	rSize        = 32
+	indexKeySize = rSize + 8 + 4 + 4 + 4
+)
+
+// tipKey holds the last block recorded with Batch.SetTip. It's shorter
+// than the signature keys, which is how the iterations tell it apart.
+var tipKey = []byte("tip")
+
+// Index is an exact on-disk index of every signature R value. Each use
+// is a key made of R, height, tx, txin and data index, so that leveldb
+// keeps all the uses of the same R next to each other.
+type Index struct {
+	db *leveldb.DB
+}
+
+// OpenIndex opens or creates the index in the directory path.
+func OpenIndex(path string) (*Index, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &Index{db: db}, nil
+}
+
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
+
+// RPrefix returns r as the fixed size prefix of its index keys.
+func RPrefix(r *big.Int) []byte {
+	prefix := make([]byte, rSize)
+	b := r.Bytes()
+	copy(prefix[rSize-len(b):], b)
+	return prefix
+}
+
+func indexKey(s *Signature) []byte {
+	key := make([]byte, indexKeySize)
+	copy(key, RPrefix(s.Sig.R))
+	binary.BigEndian.PutUint64(key[rSize:], uint64(s.H))
+	binary.BigEndian.PutUint32(key[rSize+8:], uint32(s.Tx))
+	binary.BigEndian.PutUint32(key[rSize+12:], uint32(s.TxIn))
+	binary.BigEndian.PutUint32(key[rSize+16:], uint32(s.Data))
+	return key
+}
+
+func decodeKey(key []byte) *Signature {
+	return &Signature{
+		H:    int64(binary.BigEndian.Uint64(key[rSize:])),
+		Tx:   int(binary.BigEndian.Uint32(key[rSize+8:])),
+		TxIn: int(binary.BigEndian.Uint32(key[rSize+12:])),
+		Data: int(binary.BigEndian.Uint32(key[rSize+16:])),
+	}
+}
+
+func prefixRange(prefix []byte) *util.Range {
+	limit := new(big.Int).SetBytes(prefix)
+	limit.Add(limit, big.NewInt(1))
+	return &util.Range{Start: prefix, Limit: RPrefix(limit)}
+}
+
+// Has reports whether the index contains any use of r.
+func (idx *Index) Has(r *big.Int) (bool, error) {
+	iter := idx.db.NewIterator(prefixRange(RPrefix(r)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if len(iter.Key()) == indexKeySize {
+			return true, nil
+		}
+	}
+	return false, iter.Error()
+}
+
+// Tip returns the block last recorded with Batch.SetTip, or a nil sha if
+// there is none.
+func (idx *Index) Tip() (*btcwire.ShaHash, int64, error) {
+	value, err := idx.db.Get(tipKey, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(value) != btcwire.HashSize+8 {
+		return nil, 0, fmt.Errorf("corrupted index tip")
+	}
+	sha, err := btcwire.NewShaHash(value[:btcwire.HashSize])
+	if err != nil {
+		return nil, 0, err
+	}
+	return sha, int64(binary.BigEndian.Uint64(value[btcwire.HashSize:])), nil
+}
+
+// Lookup returns all the uses of r, in chain order. The returned
+// signatures only have the position set.
+func (idx *Index) Lookup(r *big.Int) ([]*Signature, error) {
+	iter := idx.db.NewIterator(prefixRange(RPrefix(r)), nil)
+	defer iter.Release()
+
+	var sigs []*Signature
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != indexKeySize {
+			continue
+		}
+		sigs = append(sigs, decodeKey(key))
+	}
+	return sigs, iter.Error()
+}
+
+// Batch collects signatures to be added to or removed from the index at
+// once.
+type Batch struct {
+	batch  *leveldb.Batch
+	len    int
+	values map[string]struct{}
+}
+
+func NewBatch() *Batch {
+	return &Batch{
+		batch:  new(leveldb.Batch),
+		values: make(map[string]struct{}),
+	}
+}
+
+// Add queues s to be added to the index.
+func (b *Batch) Add(s *Signature) {
+	b.batch.Put(indexKey(s), nil)
+	b.len++
+	b.values[string(RPrefix(s.Sig.R))] = struct{}{}
+}
+
+// Delete queues s to be removed from the index, to undo a disconnected
+// block. Has doesn't account for it.
+func (b *Batch) Delete(s *Signature) {
+	b.batch.Delete(indexKey(s))
+	b.len++
+}
+
+// SetTip records sha at height as the last block in the index, together
+// with the rest of the batch.
+func (b *Batch) SetTip(sha *btcwire.ShaHash, height int64) {
+	value := make([]byte, btcwire.HashSize+8)
+	copy(value, sha.Bytes())
+	binary.BigEndian.PutUint64(value[btcwire.HashSize:], uint64(height))
+	b.batch.Put(tipKey, value)
+}
+
+// Has reports whether the batch contains any use of r.
+func (b *Batch) Has(r *big.Int) bool {
+	_, ok := b.values[string(RPrefix(r))]
+	return ok
+}
+
+// Len returns the number of signatures added or removed in the batch.
+func (b *Batch) Len() int {
+	return b.len
+}
+
+// Write applies the changes in b to the index, and empties b.
+func (idx *Index) Write(b *Batch) error {
+	err := idx.db.Write(b.batch, nil)
+	b.batch.Reset()
+	b.len = 0
+	b.values = make(map[string]struct{})
+	return err
+}
+
+// DeleteFrom removes from the index all the signatures at height or
+// above, and returns how many. Since the index is sorted by R, this is a
+// pass over the whole index, meant for undoing the blocks disconnected by
+// a reorg when they are not available anymore.
+func (idx *Index) DeleteFrom(height int64) (int, error) {
+	iter := idx.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	batchLen, deleted := 0, 0
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != indexKeySize || decodeKey(key).H < height {
+			continue
+		}
+		// The iterator reuses its buffers
+		batch.Delete(append([]byte(nil), key...))
+		batchLen++
+		deleted++
+		if batchLen >= IndexBatchSize {
+			if err := idx.db.Write(batch, nil); err != nil {
+				return deleted, err
+			}
+			batch.Reset()
+			batchLen = 0
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return deleted, err
+	}
+	return deleted, idx.db.Write(batch, nil)
+}
+
+// Merge copies all the entries of the index in path, usually built by
+// another shard, into idx.
+func (idx *Index) Merge(path string) error {
+	shard, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return err
+	}
+	defer shard.Close()
+
+	iter := shard.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	batchLen := 0
+	for iter.Next() {
+		if len(iter.Key()) != indexKeySize {
+			continue
+		}
+		// The iterator reuses its buffers
+		batch.Put(append([]byte(nil), iter.Key()...), nil)
+		batchLen++
+		if batchLen >= IndexBatchSize {
+			if err := idx.db.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+			batchLen = 0
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return idx.db.Write(batch, nil)
+}
+
+// Repeated walks the whole index and returns all the R values, in
+// decimal, that are used more than once.
+func (idx *Index) Repeated() (map[string][]*Signature, error) {
+	iter := idx.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	duplicates := make(map[string][]*Signature)
+	var prefix []byte
+	var group []*Signature
+	collect := func() {
+		if len(group) > 1 {
+			duplicates[new(big.Int).SetBytes(prefix).String()] = group
+		}
+	}
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != indexKeySize {
+			continue
+		}
+		if prefix == nil || string(key[:rSize]) != string(prefix) {
+			collect()
+			prefix = append([]byte(nil), key[:rSize]...)
+			group = nil
+		}
+		group = append(group, decodeKey(key))
+	}
+	collect()
+	return duplicates, iter.Error()
+}
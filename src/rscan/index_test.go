@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcwire"
+)
+
+func testSig(r int64, h int64, tx, txIn, data int) *Signature {
+	return &Signature{
+		Sig:  &btcec.Signature{R: big.NewInt(r), S: big.NewInt(1)},
+		H:    h,
+		Tx:   tx,
+		TxIn: txIn,
+		Data: data,
+	}
+}
+
+// positions strips the signatures, that the index doesn't store.
+func positions(sigs []*Signature) []*Signature {
+	res := make([]*Signature, len(sigs))
+	for i, s := range sigs {
+		p := *s
+		p.Sig = nil
+		res[i] = &p
+	}
+	return res
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := OpenIndex(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	// R 256 right after 255 checks that the prefixes don't overlap
+	sigs := []*Signature{
+		testSig(255, 1, 1, 0, 0),
+		testSig(256, 2, 1, 0, 0),
+		testSig(255, 3, 2, 1, 0),
+		testSig(7, 3, 2, 1, 1),
+	}
+	batch := NewBatch()
+	for _, s := range sigs[:2] {
+		batch.Add(s)
+	}
+	if !batch.Has(big.NewInt(255)) || batch.Has(big.NewInt(7)) {
+		t.Errorf("Batch.Has is wrong")
+	}
+	if batch.Len() != 2 {
+		t.Errorf("Batch.Len is %v, want 2", batch.Len())
+	}
+	if err := idx.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Len() != 0 || batch.Has(big.NewInt(255)) {
+		t.Errorf("Write didn't empty the batch")
+	}
+
+	if ok, err := idx.Has(big.NewInt(256)); !ok || err != nil {
+		t.Errorf("Has(256) = %v, %v", ok, err)
+	}
+	if ok, err := idx.Has(big.NewInt(7)); ok || err != nil {
+		t.Errorf("Has(7) = %v, %v", ok, err)
+	}
+
+	// Build a second index and merge it
+	shard, err := OpenIndex(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sigs[2:] {
+		batch.Add(s)
+	}
+	if err := shard.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	shard.Close()
+	if err := idx.Merge(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := idx.Lookup(big.NewInt(255))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := positions([]*Signature{sigs[0], sigs[2]})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup(255) = %v, want %v", got, want)
+	}
+
+	repeated, err := idx.Repeated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRepeated := map[string][]*Signature{"255": want}
+	if !reflect.DeepEqual(repeated, wantRepeated) {
+		t.Errorf("Repeated() = %v, want %v", repeated, wantRepeated)
+	}
+
+	// Undo the last block
+	batch.Delete(sigs[2])
+	batch.Delete(sigs[3])
+	if err := idx.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := idx.Lookup(big.NewInt(255)); err != nil || !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Lookup(255) after Delete = %v, %v", got, err)
+	}
+	if ok, err := idx.Has(big.NewInt(7)); ok || err != nil {
+		t.Errorf("Has(7) after Delete = %v, %v", ok, err)
+	}
+
+	// The tip is stored next to the signatures without showing up in them
+	if sha, _, err := idx.Tip(); sha != nil || err != nil {
+		t.Errorf("Tip() of a new index = %v, %v", sha, err)
+	}
+	tip := btcwire.ShaHash{0x74, 0x69, 0x70}
+	batch.SetTip(&tip, 3)
+	if err := idx.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if sha, height, err := idx.Tip(); err != nil || height != 3 || !sha.IsEqual(&tip) {
+		t.Errorf("Tip() = %v, %v, %v", sha, height, err)
+	}
+	if repeated, err := idx.Repeated(); err != nil || !reflect.DeepEqual(repeated, map[string][]*Signature{}) {
+		t.Errorf("Repeated() after SetTip = %v, %v", repeated, err)
+	}
+
+	// Undo everything from height 2, without the blocks
+	if n, err := idx.DeleteFrom(2); n != 1 || err != nil {
+		t.Errorf("DeleteFrom(2) = %v, %v, want 1", n, err)
+	}
+	if ok, err := idx.Has(big.NewInt(256)); ok || err != nil {
+		t.Errorf("Has(256) after DeleteFrom = %v, %v", ok, err)
+	}
+	if ok, err := idx.Has(big.NewInt(255)); !ok || err != nil {
+		t.Errorf("Has(255) after DeleteFrom = %v, %v", ok, err)
+	}
+	if sha, _, err := idx.Tip(); sha == nil || err != nil {
+		t.Errorf("DeleteFrom removed the tip")
+	}
+}
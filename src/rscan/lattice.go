@@ -0,0 +1,128 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"math/big"
+)
+
+// lll reduces the basis b in place with the integral LLL algorithm, with
+// δ = 99/100, as in Cohen, A Course in Computational Algebraic Number
+// Theory, algorithm 2.6.7. The rows of b must be linearly independent.
+func lll(b [][]*big.Int) {
+	n := len(b)
+	if n < 2 {
+		return
+	}
+
+	// 1-based, as in the book: d[0] = 1 and lambda[k][j] for j < k
+	d := make([]*big.Int, n+1)
+	lambda := make([][]*big.Int, n+1)
+	for i := range lambda {
+		lambda[i] = make([]*big.Int, n+1)
+		for j := range lambda[i] {
+			lambda[i][j] = new(big.Int)
+		}
+	}
+	bv := func(i int) []*big.Int { return b[i-1] }
+
+	d[0] = big.NewInt(1)
+	d[1] = dot(bv(1), bv(1))
+
+	t, u := new(big.Int), new(big.Int)
+
+	red := func(k, l int) {
+		t.Lsh(lambda[k][l], 1)
+		if t.CmpAbs(d[l]) <= 0 {
+			return
+		}
+		// q = round(lambda[k][l] / d[l])
+		q := new(big.Int).Lsh(lambda[k][l], 1)
+		q.Add(q, d[l])
+		q.Div(q, new(big.Int).Lsh(d[l], 1))
+
+		for i, x := range bv(l) {
+			bv(k)[i].Sub(bv(k)[i], t.Mul(q, x))
+		}
+		lambda[k][l].Sub(lambda[k][l], t.Mul(q, d[l]))
+		for i := 1; i < l; i++ {
+			lambda[k][i].Sub(lambda[k][i], t.Mul(q, lambda[l][i]))
+		}
+	}
+
+	swap := func(k, kmax int) {
+		b[k-1], b[k-2] = b[k-2], b[k-1]
+		for j := 1; j <= k-2; j++ {
+			lambda[k][j], lambda[k-1][j] = lambda[k-1][j], lambda[k][j]
+		}
+		l := new(big.Int).Set(lambda[k][k-1])
+		B := new(big.Int).Mul(d[k-2], d[k])
+		B.Add(B, t.Mul(l, l))
+		B.Quo(B, d[k-1])
+		for i := k + 1; i <= kmax; i++ {
+			tt := new(big.Int).Set(lambda[i][k])
+			lambda[i][k].Mul(d[k], lambda[i][k-1])
+			lambda[i][k].Sub(lambda[i][k], t.Mul(l, tt))
+			lambda[i][k].Quo(lambda[i][k], d[k-1])
+			lambda[i][k-1].Mul(B, tt)
+			lambda[i][k-1].Add(lambda[i][k-1], t.Mul(l, lambda[i][k]))
+			lambda[i][k-1].Quo(lambda[i][k-1], d[k])
+		}
+		d[k-1] = B
+	}
+
+	k, kmax := 2, 1
+	for k <= n {
+		if k > kmax {
+			kmax = k
+			for j := 1; j <= k; j++ {
+				u.Set(dot(bv(k), bv(j)))
+				for i := 1; i < j; i++ {
+					u.Mul(u, d[i])
+					u.Sub(u, t.Mul(lambda[k][i], lambda[j][i]))
+					u.Quo(u, d[i-1])
+				}
+				if j < k {
+					lambda[k][j].Set(u)
+				} else {
+					d[k] = new(big.Int).Set(u)
+				}
+			}
+		}
+
+		for {
+			red(k, k-1)
+
+			// Swap if 100 d[k] d[k-2] < 99 d[k-1]^2 - 100 lambda[k][k-1]^2
+			lhs := new(big.Int).Mul(d[k], d[k-2])
+			lhs.Mul(lhs, big.NewInt(100))
+			rhs := new(big.Int).Mul(d[k-1], d[k-1])
+			rhs.Mul(rhs, big.NewInt(99))
+			l2 := new(big.Int).Mul(lambda[k][k-1], lambda[k][k-1])
+			rhs.Sub(rhs, l2.Mul(l2, big.NewInt(100)))
+			if lhs.Cmp(rhs) >= 0 {
+				break
+			}
+
+			swap(k, kmax)
+			if k > 2 {
+				k--
+			}
+		}
+
+		for l := k - 2; l >= 1; l-- {
+			red(k, l)
+		}
+		k++
+	}
+}
+
+func dot(a, b []*big.Int) *big.Int {
+	res, t := new(big.Int), new(big.Int)
+	for i := range a {
+		res.Add(res, t.Mul(a[i], b[i]))
+	}
+	return res
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+	"reflect"
+
+	"github.com/conformal/btcec"
+)
+
+var (
+	ErrDifferentR = errors.New("Different R!")
+	ErrVerifyA    = errors.New("A fails to verify!")
+	ErrVerifyB    = errors.New("B fails to verify!")
+	ErrCurve      = errors.New("What the curve?!")
+	ErrX          = errors.New("X!")
+	ErrY          = errors.New("Y!")
+	ErrSameHash   = errors.New("Same hash, a malleated copy!")
+)
+
+// from crypto/ecdsa
+func hashToInt(hash []byte, c elliptic.Curve) *big.Int {
+	orderBits := c.Params().N.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(hash) > orderBytes {
+		hash = hash[:orderBytes]
+	}
+
+	ret := new(big.Int).SetBytes(hash)
+	excess := len(hash)*8 - orderBits
+	if excess > 0 {
+		ret.Rsh(ret, uint(excess))
+	}
+	return ret
+}
+
+// RecoverKey computes the private key that made a and b, two signatures
+// of the same key with the same R. Since anyone can replace S with N-S,
+// both forms of b are tried. Two signatures of the same hash share R
+// without sharing a secret, and give ErrSameHash.
+func RecoverKey(a, b *Verified) (*btcec.PrivateKey, error) {
+	sigA, sigB := a.Signature, b.Signature
+	hashA, hashB := a.Hash, b.Hash
+	pubKey := a.PubKey
+
+	// Sanity checks
+	if sigA.R.Cmp(sigB.R) != 0 {
+		return nil, ErrDifferentR
+	}
+	if !ecdsa.Verify(pubKey.ToECDSA(), hashA, sigA.R, sigA.S) {
+		return nil, ErrVerifyA
+	}
+	if !ecdsa.Verify(pubKey.ToECDSA(), hashB, sigB.R, sigB.S) {
+		return nil, ErrVerifyB
+	}
+	if !reflect.DeepEqual(pubKey.Curve, btcec.S256()) {
+		return nil, ErrCurve
+	}
+
+	c := btcec.S256()
+
+	N := c.Params().N
+	zA := hashToInt(hashA, c)
+	zB := hashToInt(hashB, c)
+	if zA.Cmp(zB) == 0 {
+		return nil, ErrSameHash
+	}
+
+	zDiff := new(big.Int).Sub(zA, zB)
+	zDiff.Mod(zDiff, N)
+
+	rInv := new(big.Int).ModInverse(sigA.R, N)
+
+	err := ErrX
+	for _, sB := range []*big.Int{sigB.S, new(big.Int).Sub(N, sigB.S)} {
+		sDiffInv := new(big.Int).Sub(sigA.S, sB)
+		sDiffInv.Mod(sDiffInv, N)
+		if sDiffInv.ModInverse(sDiffInv, N) == nil {
+			continue
+		}
+
+		k := new(big.Int).Mul(zDiff, sDiffInv)
+		k.Mod(k, N)
+
+		D := new(big.Int)
+		D.Mul(sigA.S, k)
+		D.Sub(D, zA)
+		D.Mul(D, rInv)
+		D.Mod(D, N)
+
+		x, y := c.ScalarBaseMult(D.Bytes())
+		if pubKey.X.Cmp(x) != 0 {
+			continue
+		}
+		if pubKey.Y.Cmp(y) != 0 {
+			err = ErrY
+			continue
+		}
+
+		return &btcec.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: c,
+				X:     x,
+				Y:     y,
+			},
+			D: D,
+		}, nil
+	}
+
+	return nil, err
+}
+
+// Malleated reports whether a and b are the same signature, up to the
+// sign of S, of the same hash by the same key: a copy of a spend with a
+// different txid, made by replacing S with N-S or by changing the
+// encoding, rather than a reused nonce.
+func Malleated(a, b *Verified) bool {
+	if !bytes.Equal(a.Hash, b.Hash) || a.PubKey.X.Cmp(b.PubKey.X) != 0 ||
+		a.PubKey.Y.Cmp(b.PubKey.Y) != 0 {
+		return false
+	}
+	sigA, sigB := a.Signature.LowS(), b.Signature.LowS()
+	return sigA.R.Cmp(sigB.R) == 0 && sigA.S.Cmp(sigB.S) == 0
+}
+
+// RecoverNonce computes the nonce used for v by the owner of privKey.
+func RecoverNonce(v *Verified, privKey *btcec.PrivateKey) *big.Int {
+	c := btcec.S256()
+	N := c.Params().N
+	z := hashToInt(v.Hash, c)
+
+	sInv := new(big.Int).ModInverse(v.Signature.S, N)
+	if sInv == nil {
+		return nil
+	}
+
+	k := new(big.Int).Mul(v.Signature.R, privKey.D)
+	k.Add(k, z)
+	k.Mul(k, sInv)
+	k.Mod(k, N)
+	return k
+}
+
+// RecoverKeyFromNonce computes the private key that made v with the
+// nonce k. Since k and N-k give the same R, and anyone can replace S with
+// N-S, both are tried.
+func RecoverKeyFromNonce(v *Verified, k *big.Int) *btcec.PrivateKey {
+	c := btcec.S256()
+	N := c.Params().N
+	z := hashToInt(v.Hash, c)
+
+	rInv := new(big.Int).ModInverse(v.Signature.R, N)
+	if rInv == nil {
+		return nil
+	}
+
+	for _, nonce := range []*big.Int{k, new(big.Int).Sub(N, k)} {
+		D := new(big.Int).Mul(v.Signature.S, nonce)
+		D.Sub(D, z)
+		D.Mul(D, rInv)
+		D.Mod(D, N)
+
+		if privKey := privKeyFromD(D, v.PubKey); privKey != nil {
+			return privKey
+		}
+	}
+
+	return nil
+}
+
+// privKeyFromD returns d as a private key if it matches pubKey.
+func privKeyFromD(d *big.Int, pubKey *btcec.PublicKey) *btcec.PrivateKey {
+	c := btcec.S256()
+	d = new(big.Int).Mod(d, c.Params().N)
+	if d.Sign() == 0 {
+		return nil
+	}
+	x, y := c.ScalarBaseMult(d.Bytes())
+	if pubKey.X.Cmp(x) != 0 || pubKey.Y.Cmp(y) != 0 {
+		return nil
+	}
+	return &btcec.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: c,
+			X:     x,
+			Y:     y,
+		},
+		D: d,
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/conformal/btcec"
+)
+
+// testKey derives a deterministic private key from seed.
+func testKey(seed string) *btcec.PrivateKey {
+	h := sha256.Sum256([]byte(seed))
+	k, _ := btcec.PrivKeyFromBytes(btcec.S256(), h[:])
+	return k
+}
+
+func testPubKey(k *btcec.PrivateKey) *btcec.PublicKey {
+	return (*btcec.PublicKey)(&k.PublicKey)
+}
+
+// signWithNonce signs the hash of msg with privKey and the nonce k,
+// without normalizing S.
+func signWithNonce(privKey *btcec.PrivateKey, msg string, k *big.Int) *Verified {
+	c := btcec.S256()
+	N := c.Params().N
+
+	h := sha256.Sum256([]byte(msg))
+	z := hashToInt(h[:], c)
+
+	r, _ := c.ScalarBaseMult(k.Bytes())
+	r.Mod(r, N)
+	s := new(big.Int).Mul(r, privKey.D)
+	s.Add(s, z)
+	s.Mul(s, new(big.Int).ModInverse(k, N))
+	s.Mod(s, N)
+
+	return &Verified{
+		Signature: &btcec.Signature{R: r, S: s},
+		PubKey:    testPubKey(privKey),
+		Hash:      h[:],
+	}
+}
+
+func negateS(v *Verified) *Verified {
+	n := *v
+	n.Signature = &btcec.Signature{
+		R: v.Signature.R,
+		S: new(big.Int).Sub(btcec.S256().Params().N, v.Signature.S),
+	}
+	return &n
+}
+
+func TestRecoverKey(t *testing.T) {
+	privKey := testKey("a")
+	k := testKey("k").D
+
+	a := signWithNonce(privKey, "a", k)
+	b := signWithNonce(privKey, "b", k)
+
+	// Either S might have been negated, by the wallet or by anyone else
+	for _, pair := range [][2]*Verified{{a, b}, {a, negateS(b)}, {negateS(a), b}} {
+		res, err := RecoverKey(pair[0], pair[1])
+		if err != nil {
+			t.Fatalf("RecoverKey: %v", err)
+		}
+		if res.D.Cmp(privKey.D) != 0 {
+			t.Errorf("RecoverKey returned the wrong key")
+		}
+	}
+
+	if _, err := RecoverKey(a, negateS(a)); err != ErrSameHash {
+		t.Errorf("RecoverKey with a malleated copy: got %v, want %v", err, ErrSameHash)
+	}
+
+	c := signWithNonce(privKey, "c", testKey("other").D)
+	if _, err := RecoverKey(a, c); err != ErrDifferentR {
+		t.Errorf("RecoverKey with different R: got %v, want %v", err, ErrDifferentR)
+	}
+
+	wrong := *b
+	wrong.Hash = a.Hash
+	if _, err := RecoverKey(a, &wrong); err != ErrVerifyB {
+		t.Errorf("RecoverKey with a bad signature: got %v, want %v", err, ErrVerifyB)
+	}
+}
+
+func TestMalleated(t *testing.T) {
+	privKey := testKey("a")
+	k := testKey("k").D
+	a := signWithNonce(privKey, "a", k)
+
+	tests := []struct {
+		name string
+		b    *Verified
+		want bool
+	}{
+		{"same", signWithNonce(privKey, "a", k), true},
+		{"negated S", negateS(a), true},
+		{"other hash", signWithNonce(privKey, "b", k), false},
+		{"other key", signWithNonce(testKey("b"), "a", k), false},
+		{"other nonce", signWithNonce(privKey, "a", testKey("other").D), false},
+	}
+	for _, test := range tests {
+		if got := Malleated(a, test.b); got != test.want {
+			t.Errorf("%v: Malleated = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRecoverNonce(t *testing.T) {
+	privKey := testKey("a")
+	other := testKey("b")
+	k := testKey("k").D
+
+	a := signWithNonce(privKey, "a", k)
+	if n := RecoverNonce(a, privKey); n == nil || n.Cmp(k) != 0 {
+		t.Fatalf("RecoverNonce returned %v, want %v", n, k)
+	}
+
+	// The same nonce breaks another key, even if S was negated
+	for _, b := range []*Verified{
+		signWithNonce(other, "b", k),
+		negateS(signWithNonce(other, "b", k)),
+	} {
+		res := RecoverKeyFromNonce(b, k)
+		if res == nil || res.D.Cmp(other.D) != 0 {
+			t.Errorf("RecoverKeyFromNonce failed")
+		}
+	}
+
+	if res := RecoverKeyFromNonce(a, big.NewInt(42)); res != nil {
+		t.Errorf("RecoverKeyFromNonce succeeded with the wrong nonce")
+	}
+}
+
+func TestRecoverAffine(t *testing.T) {
+	privKey := testKey("a")
+	N := btcec.S256().Params().N
+	k1 := testKey("k").D
+
+	tests := []struct {
+		a, b    int64
+		maxDiff int64
+	}{
+		{1, 0, 0},
+		{1, 1, 10},
+		{1, -5, 10},
+		{1, 77777, 1 << 20},
+		{3, -77777, 1 << 20},
+	}
+
+	for _, test := range tests {
+		k2 := new(big.Int).Mul(k1, big.NewInt(test.a))
+		k2.Add(k2, big.NewInt(test.b))
+		k2.Mod(k2, N)
+
+		x := signWithNonce(privKey, "x", k1)
+		y := signWithNonce(privKey, "y", k2)
+
+		res := RecoverAffine(x, y, test.a, test.maxDiff)
+		if res == nil || res.D.Cmp(privKey.D) != 0 {
+			t.Errorf("k2 = %v*k1 + %v: RecoverAffine failed", test.a, test.b)
+		}
+		if test.a == 1 {
+			continue
+		}
+		if res := RecoverAffine(x, y, test.a-1, test.maxDiff); res != nil {
+			t.Errorf("k2 = %v*k1 + %v: RecoverAffine succeeded with a = %v", test.a, test.b, test.a-1)
+		}
+	}
+
+	x := signWithNonce(privKey, "x", k1)
+	y := signWithNonce(privKey, "y", new(big.Int).Add(k1, big.NewInt(100)))
+	if res := RecoverAffine(x, y, 1, 10); res != nil {
+		t.Errorf("RecoverAffine succeeded with a difference out of range")
+	}
+}
+
+func TestRecoverBiased(t *testing.T) {
+	privKey := testKey("a")
+
+	for _, bits := range []int{128, 64} {
+		for _, low := range []bool{false, true} {
+			var sigs []*Verified
+			for i := 0; i < 256/bits+4; i++ {
+				seed := string([]byte{byte(bits), byte(i)})
+				k := new(big.Int).Rsh(testKey(seed).D, uint(bits))
+				if low {
+					k.Lsh(k, uint(bits))
+				}
+				sigs = append(sigs, signWithNonce(privKey, seed, k))
+			}
+
+			res := RecoverBiased(sigs, bits, low)
+			if res == nil || res.D.Cmp(privKey.D) != 0 {
+				t.Errorf("%v bits, low %v: RecoverBiased failed", bits, low)
+			}
+			if res := RecoverBiased(sigs, bits, !low); res != nil {
+				t.Errorf("%v bits, low %v: RecoverBiased succeeded on the wrong end", bits, low)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"math/big"
+
+	"github.com/conformal/btcec"
+)
+
+// Related nonces
+//
+// Exact R reuse is the simplest case of a broken RNG, but nonces that are
+// only related are just as fatal. For a signature (r, s) of z, the nonce
+// is k = t*d + u with t = r/s and u = z/s, so any known linear relation
+// between two nonces, or enough nonces with known zero bits, is a linear
+// problem in the private key d.
+
+// NonceCoeffs returns t and u such that the nonce of v is t*d + u, where
+// d is the private key.
+func NonceCoeffs(v *Verified) (t, u *big.Int) {
+	c := btcec.S256()
+	N := c.Params().N
+
+	sInv := new(big.Int).ModInverse(v.Signature.S, N)
+	t = new(big.Int).Mul(v.Signature.R, sInv)
+	t.Mod(t, N)
+	u = new(big.Int).Mul(hashToInt(v.Hash, c), sInv)
+	u.Mod(u, N)
+	return
+}
+
+// RecoverAffine looks for a relation k_y = a*k_x + b between the nonces
+// of two signatures of the same key, with |b| <= maxDiff, and returns the
+// private key if it finds one.
+//
+// Since k_y - a*k_x = (t_y - a*t_x)*d + (u_y - a*u_x), the relation gives
+// d = b/D - c with D = t_y - a*t_x and c = (u_y - a*u_x)/D. Then
+// Q = pub + c*G must be b*(G/D), and b is found with a baby-step
+// giant-step search.
+func RecoverAffine(x, y *Verified, a, maxDiff int64) *btcec.PrivateKey {
+	curve := btcec.S256()
+	N := curve.Params().N
+
+	tX, uX := NonceCoeffs(x)
+	tY, uY := NonceCoeffs(y)
+	A := big.NewInt(a)
+
+	D := new(big.Int).Mul(A, tX)
+	D.Sub(tY, D)
+	D.Mod(D, N)
+	DInv := new(big.Int).ModInverse(D, N)
+	if DInv == nil {
+		return nil
+	}
+
+	c := new(big.Int).Mul(A, uX)
+	c.Sub(uY, c)
+	c.Mul(c, DInv)
+	c.Mod(c, N)
+
+	// Search b + maxDiff in [0, 2*maxDiff]
+	px, py := curve.ScalarBaseMult(DInv.Bytes())
+	qx, qy := curve.ScalarBaseMult(c.Bytes())
+	qx, qy = curve.Add(qx, qy, x.PubKey.X, x.PubKey.Y)
+	if maxDiff > 0 {
+		sx, sy := curve.ScalarMult(px, py, big.NewInt(maxDiff).Bytes())
+		qx, qy = curve.Add(qx, qy, sx, sy)
+	}
+
+	m := int64(1)
+	for m*m < 2*maxDiff+1 {
+		m++
+	}
+
+	check := func(b int64) *btcec.PrivateKey {
+		d := big.NewInt(b - maxDiff)
+		d.Mul(d, DInv)
+		d.Sub(d, c)
+		return privKeyFromD(d, x.PubKey)
+	}
+
+	// Baby steps: j*P for j in [1, m), by X coordinate, so that a match
+	// means j*P = ±(Q - i*m*P)
+	baby := make(map[string]int64, m)
+	jx, jy := px, py
+	for j := int64(1); j < m; j++ {
+		baby[string(jx.Bytes())] = j
+		jx, jy = curve.Add(jx, jy, px, py)
+	}
+
+	mx, my := curve.ScalarMult(px, py, big.NewInt(m).Bytes())
+	my = new(big.Int).Sub(curve.Params().P, my)
+	gx, gy := qx, qy
+	for i := int64(0); i <= m; i++ {
+		if gx.Sign() == 0 && gy.Sign() == 0 {
+			if k := check(i * m); k != nil {
+				return k
+			}
+		} else if j, ok := baby[string(gx.Bytes())]; ok {
+			if k := check(i*m + j); k != nil {
+				return k
+			}
+			if k := check(i*m - j); k != nil {
+				return k
+			}
+		}
+		gx, gy = curve.Add(gx, gy, mx, my)
+	}
+
+	return nil
+}
+
+// RecoverBiased runs the lattice attack on the Hidden Number Problem,
+// assuming that the nonces of sigs, all made by the same key, have their
+// top bits (or, if low is true, their bottom bits) set to zero. About
+// 256/bits signatures are needed.
+func RecoverBiased(sigs []*Verified, bits int, low bool) *btcec.PrivateKey {
+	N := btcec.S256().Params().N
+	m := len(sigs)
+	if m == 0 || bits <= 0 || bits >= N.BitLen() {
+		return nil
+	}
+
+	// The nonces are smaller than K, and recentered around zero
+	K := new(big.Int).Lsh(big.NewInt(1), uint(N.BitLen()-bits))
+	half := new(big.Int).Rsh(K, 1)
+
+	var shiftInv *big.Int
+	if low {
+		shiftInv = new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		shiftInv.ModInverse(shiftInv, N)
+	}
+
+	ts := make([]*big.Int, m)
+	us := make([]*big.Int, m)
+	for i, v := range sigs {
+		t, u := NonceCoeffs(v)
+		if low {
+			t.Mul(t, shiftInv)
+			t.Mod(t, N)
+			u.Mul(u, shiftInv)
+			u.Mod(u, N)
+		}
+		u.Sub(u, half)
+		u.Mod(u, N)
+		ts[i], us[i] = t, u
+	}
+
+	// The rows are N^2*e_i, (N*t_i, K, 0) and (N*u_i, 0, N*K): the vector
+	// (N*(k_i - K/2), d*K, N*K) is in the lattice, and it's short.
+	N2 := new(big.Int).Mul(N, N)
+	NK := new(big.Int).Mul(N, K)
+	basis := make([][]*big.Int, m+2)
+	for i := range basis {
+		basis[i] = make([]*big.Int, m+2)
+		for j := range basis[i] {
+			basis[i][j] = new(big.Int)
+		}
+	}
+	for i := 0; i < m; i++ {
+		basis[i][i].Set(N2)
+		basis[m][i].Mul(N, ts[i])
+		basis[m+1][i].Mul(N, us[i])
+	}
+	basis[m][m].Set(K)
+	basis[m+1][m+1].Set(NK)
+
+	lll(basis)
+
+	for _, row := range basis {
+		d := new(big.Int).Set(row[m])
+		switch {
+		case row[m+1].Cmp(NK) == 0:
+		case new(big.Int).Neg(row[m+1]).Cmp(NK) == 0:
+			d.Neg(d)
+		default:
+			continue
+		}
+		if new(big.Int).Mod(d, K).Sign() != 0 {
+			continue
+		}
+		d.Quo(d, K)
+		if k := privKeyFromD(d, sigs[0].PubKey); k != nil {
+			return k
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// RPCSource is a Source that asks a btcd node over JSON-RPC, with
+// getblockhash, getblock and getrawtransaction.
+type RPCSource struct {
+	server, user, pass string
+
+	// cert is the PEM certificate of the server, nil if not using TLS
+	cert []byte
+}
+
+// NewRPCSource returns a Source asking the btcd RPC server at server
+// (host:port). certFile is the server TLS certificate, unless noTLS is set.
+func NewRPCSource(server, user, pass, certFile string, noTLS bool) (*RPCSource, error) {
+	s := &RPCSource{server: server, user: user, pass: pass}
+	if !noTLS {
+		cert, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		s.cert = cert
+	}
+	return s, nil
+}
+
+func (s *RPCSource) send(cmd btcjson.Cmd, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	var reply btcjson.Reply
+	if s.cert != nil {
+		reply, err = btcjson.TlsRpcSend(s.user, s.pass, s.server, cmd, s.cert, false)
+	} else {
+		reply, err = btcjson.RpcSend(s.user, s.pass, s.server, cmd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", cmd.Method(), err)
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("%v: %v", cmd.Method(), reply.Error)
+	}
+	return reply.Result, nil
+}
+
+func (s *RPCSource) blockResult(sha *btcwire.ShaHash) (*btcjson.BlockResult, error) {
+	res, err := s.send(btcjson.NewGetBlockCmd(nil, sha.String(), true))
+	if err != nil {
+		return nil, err
+	}
+	blk, ok := res.(*btcjson.BlockResult)
+	if !ok {
+		return nil, fmt.Errorf("getblock: unexpected result %T", res)
+	}
+	return blk, nil
+}
+
+func (s *RPCSource) Tip() (*btcwire.ShaHash, int64, error) {
+	res, err := s.send(btcjson.NewGetBestBlockHashCmd(nil))
+	if err != nil {
+		return nil, 0, err
+	}
+	hash, ok := res.(string)
+	if !ok {
+		return nil, 0, fmt.Errorf("getbestblockhash: unexpected result %T", res)
+	}
+	sha, err := btcwire.NewShaHashFromStr(hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	height, err := s.BlockHeight(sha)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sha, height, nil
+}
+
+func (s *RPCSource) BlockSha(height int64) (*btcwire.ShaHash, error) {
+	res, err := s.send(btcjson.NewGetBlockHashCmd(nil, height))
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := res.(string)
+	if !ok {
+		return nil, fmt.Errorf("getblockhash: unexpected result %T", res)
+	}
+	return btcwire.NewShaHashFromStr(hash)
+}
+
+func (s *RPCSource) BlockByHeight(height int64) (*btcutil.Block, error) {
+	sha, err := s.BlockSha(height)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.send(btcjson.NewGetBlockCmd(nil, sha.String(), false))
+	if err != nil {
+		return nil, err
+	}
+	blkHex, ok := res.(string)
+	if !ok {
+		return nil, fmt.Errorf("getblock: unexpected result %T", res)
+	}
+	buf, err := hex.DecodeString(blkHex)
+	if err != nil {
+		return nil, fmt.Errorf("getblock: %v", err)
+	}
+	blk, err := btcutil.NewBlockFromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("getblock: %v", err)
+	}
+	blk.SetHeight(height)
+	return blk, nil
+}
+
+func (s *RPCSource) BlockHeight(sha *btcwire.ShaHash) (int64, error) {
+	blk, err := s.blockResult(sha)
+	if err != nil {
+		return 0, err
+	}
+	return blk.Height, nil
+}
+
+func (s *RPCSource) TxBySha(sha *btcwire.ShaHash) (*btcwire.MsgTx, int64, error) {
+	res, err := s.send(btcjson.NewGetRawTransactionCmd(nil, sha.String(), 1))
+	if err != nil {
+		return nil, 0, err
+	}
+	txRes, ok := res.(*btcjson.TxRawResult)
+	if !ok {
+		return nil, 0, fmt.Errorf("getrawtransaction: unexpected result %T", res)
+	}
+	if txRes.BlockHash == "" {
+		return nil, 0, fmt.Errorf("transaction %v is not in a block", sha)
+	}
+
+	buf, err := hex.DecodeString(txRes.Hex)
+	if err != nil {
+		return nil, 0, fmt.Errorf("getrawtransaction: %v", err)
+	}
+	tx, err := btcutil.NewTxFromBytes(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("getrawtransaction: %v", err)
+	}
+
+	blkSha, err := btcwire.NewShaHashFromStr(txRes.BlockHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	height, err := s.BlockHeight(blkSha)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tx.MsgTx(), height, nil
+}
+
+// Close does nothing, every request is a new connection.
+func (s *RPCSource) Close() error {
+	return nil
+}
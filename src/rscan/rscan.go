@@ -0,0 +1,27 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package rscan finds ECDSA signatures that reuse the same R value in the
+// block chain, and recovers the private keys that made them.
+//
+// The pipeline is made of four parts: Scan extracts the signatures from
+// a range of blocks of a Source, Index keeps track of all their R values
+// on disk, Verify reconstructs the hash a signature signs, and RecoverKey
+// and its siblings do the math.
+package rscan
+
+import "github.com/conformal/btcnet"
+
+// NetParams returns the selected network, and the name of its directory
+// in the btcd data directory.
+func NetParams(testnet, regtest bool) (*btcnet.Params, string) {
+	switch {
+	case testnet:
+		return &btcnet.TestNet3Params, "testnet"
+	case regtest:
+		return &btcnet.RegressionNetParams, "regtest"
+	default:
+		return &btcnet.MainNetParams, "mainnet"
+	}
+}
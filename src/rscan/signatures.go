@@ -0,0 +1,351 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/conformal/btcchain"
+	"github.com/conformal/btcec"
+	"github.com/conformal/btclog"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+)
+
+// Signature is a signature found in the push Data of the input TxIn of
+// the transaction Tx of the block at height H.
+//
+// HashType is the sighash type appended to the signature, and Class the
+// class of the script that checks it, which for a P2SH spend is the class
+// of the redeem script. PubKey is the serialized key that the signature
+// belongs to, when the sigScript or the redeem script tell which one it
+// is. These are not stored, and can be found again with
+// SigScriptSignatures.
+type Signature struct {
+	Sig  *btcec.Signature `json:"-"`
+	H    int64
+	Tx   int
+	TxIn int
+	Data int
+
+	HashType byte                  `json:"-"`
+	Class    btcscript.ScriptClass `json:"-"`
+	P2SH     bool                  `json:"-"`
+	PubKey   []byte                `json:"-"`
+}
+
+// BlockSignatures returns all the signatures in blk, in order, and the
+// number of pushes that parsed as a signature but were rejected by
+// SigScriptSignatures.
+func BlockSignatures(blk *btcutil.Block) (sigs []*Signature, rejected int) {
+	for i, tx := range blk.MsgBlock().Transactions {
+		if btcchain.IsCoinBase(btcutil.NewTx(tx)) {
+			continue
+		}
+
+		for t, txin := range tx.TxIn {
+			s, rej := SigScriptSignatures(txin.SignatureScript)
+			for _, sig := range s {
+				sig.H = blk.Height()
+				sig.Tx = i
+				sig.TxIn = t
+			}
+			sigs = append(sigs, s...)
+			rejected += rej
+		}
+	}
+	return
+}
+
+// SigScriptSignatures returns the signatures in sigScript, with Data,
+// HashType, Class, P2SH and PubKey set. The previous output is not
+// needed: the spent script class is recognized from the shape of the
+// sigScript, that is
+//
+//	pubkeyhash:  <sig> <pubkey>
+//	pubkey:      <sig>
+//	multisig:    OP_0 <sig>...
+//	scripthash:  any of the above, followed by the redeem script
+//
+// and only the pushes in a signature position are returned. The count of
+// the other pushes that parse as a signature anyway is returned as
+// rejected, and is a measure of the false positives of treating any push
+// as a signature.
+func SigScriptSignatures(sigScript []byte) (sigs []*Signature, rejected int) {
+	pushes, err := btcscript.PushedData(sigScript)
+	if err != nil {
+		return nil, 0
+	}
+
+	var pos []int
+	var keys [][]byte
+	class, p2sh := btcscript.NonStandardTy, false
+	if btcscript.IsPushOnlyScript(sigScript) && len(pushes) > 0 {
+		// A P2SH multisig spend also looks like a bare one
+		redeem := pushes[len(pushes)-1]
+		class, pos, keys = redeemSigPositions(pushes[:len(pushes)-1], redeem)
+		p2sh = class != btcscript.NonStandardTy
+		if !p2sh {
+			class, pos, keys = sigPositions(pushes)
+		}
+	}
+
+	accepted := make(map[int]bool, len(pos))
+	for i, d := range pos {
+		data := pushes[d]
+		if len(data) == 0 {
+			// A missing multisig signature
+			continue
+		}
+		signature, err := btcec.ParseSignature(data, btcec.S256())
+		if err != nil {
+			continue
+		}
+		accepted[d] = true
+
+		sig := &Signature{
+			Sig:      signature,
+			Data:     d,
+			HashType: data[len(data)-1],
+			Class:    class,
+			P2SH:     p2sh,
+		}
+		if keys != nil {
+			sig.PubKey = keys[i]
+		}
+		sigs = append(sigs, sig)
+	}
+
+	for d, data := range pushes {
+		if accepted[d] {
+			continue
+		}
+		if _, err := btcec.ParseSignature(data, btcec.S256()); err == nil {
+			rejected++
+		}
+	}
+
+	return
+}
+
+// sigPositions recognizes the pushes of a sigScript spending a pubkeyhash,
+// pubkey or multisig output, and returns the indexes of the signatures.
+// keys, if not nil, has the corresponding pubkeys.
+func sigPositions(pushes [][]byte) (class btcscript.ScriptClass, pos []int, keys [][]byte) {
+	switch {
+	case len(pushes) == 2 && isPubKey(pushes[1]):
+		return btcscript.PubKeyHashTy, []int{0}, [][]byte{pushes[1]}
+	case len(pushes) == 1:
+		return btcscript.PubKeyTy, []int{0}, nil
+	case len(pushes) >= 2 && len(pushes[0]) == 0:
+		for d := 1; d < len(pushes); d++ {
+			pos = append(pos, d)
+		}
+		return btcscript.MultiSigTy, pos, nil
+	}
+	return btcscript.NonStandardTy, nil, nil
+}
+
+// redeemSigPositions is like sigPositions for a P2SH spend of redeem, with
+// the pushes before it. The keys are taken from the redeem script when
+// they are in it.
+func redeemSigPositions(pushes [][]byte, redeem []byte) (class btcscript.ScriptClass, pos []int, keys [][]byte) {
+	class, pos, keys = sigPositions(pushes)
+	if class == btcscript.NonStandardTy || class != btcscript.GetScriptClass(redeem) {
+		return btcscript.NonStandardTy, nil, nil
+	}
+
+	switch class {
+	case btcscript.PubKeyTy:
+		redeemKeys, err := btcscript.PushedData(redeem)
+		if err != nil || len(redeemKeys) != 1 {
+			return btcscript.NonStandardTy, nil, nil
+		}
+		keys = redeemKeys
+
+	case btcscript.MultiSigTy:
+		// Signatures are checked against the keys in order, so if
+		// all the keys must sign they match one to one
+		redeemKeys, err := btcscript.PushedData(redeem)
+		if err != nil {
+			return btcscript.NonStandardTy, nil, nil
+		}
+		numKeys, numSigs, err := btcscript.CalcMultiSigStats(redeem)
+		if err != nil || len(pos) > numSigs {
+			return btcscript.NonStandardTy, nil, nil
+		}
+		if numSigs == numKeys && len(pos) == numKeys && len(redeemKeys) == numKeys {
+			keys = redeemKeys
+		}
+	}
+
+	return
+}
+
+// isPubKey checks the length and prefix of a serialized public key.
+func isPubKey(b []byte) bool {
+	switch len(b) {
+	case 33:
+		return b[0] == 0x02 || b[0] == 0x03
+	case 65:
+		return b[0] == 0x04 || b[0] == 0x06 || b[0] == 0x07
+	}
+	return false
+}
+
+const (
+	// scanRetries is how many times Scan tries again to fetch a block
+	// before giving up.
+	scanRetries = 3
+
+	// scanSigBuffer is the buffer of the signatures channel returned by
+	// Scan.
+	scanSigBuffer = 1024
+)
+
+// scanRetryDelay is the pause before the first retry, doubled at each
+// one.
+var scanRetryDelay = time.Second
+
+// ScanResult is how a Scan ended.
+type ScanResult struct {
+	// Reached is the height of the first block that was not processed.
+	// All the blocks below it were.
+	Reached int64
+
+	// Err is the error that stopped the scan before end, if any.
+	Err error
+}
+
+// scanBlock is a block handed out to the Scan workers, and its outcome.
+type scanBlock struct {
+	h    int64
+	done chan struct{}
+
+	sigs     []*Signature
+	rejected int
+	err      error
+}
+
+// Scan extracts all the signatures in the blocks [start, end) of src,
+// fetching and parsing them with workers goroutines. The signatures are
+// sent on sigChan in chain order, so the output doesn't depend on the
+// scheduling, and the workers get at most twice their number of blocks
+// ahead of the consumer.
+//
+// When stop is closed no more blocks are fetched. A block that can't be
+// fetched, even after retrying, also stops the scan. Once sigChan has
+// been closed the ScanResult is sent on result, with the height of the
+// first block not processed, after all the goroutines exited.
+// rejected counts the pushes rejected by BlockSignatures, and must be
+// read with atomic.LoadInt64.
+func Scan(src Source, start, end int64, workers int, stop <-chan struct{},
+	log btclog.Logger) (sigChan chan *Signature, result chan ScanResult, rejected *int64) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan *scanBlock)
+	pending := make(chan *scanBlock, 2*workers)
+	quit := make(chan struct{})
+	sigChan = make(chan *Signature, scanSigBuffer)
+	result = make(chan ScanResult, 1)
+	rejected = new(int64)
+
+	// The blocks are queued in order in pending before being handed out,
+	// which bounds how far ahead the workers can go
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for h := start; h < end; h++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			b := &scanBlock{h: h, done: make(chan struct{})}
+			select {
+			case pending <- b:
+			case <-stop:
+				return
+			case <-quit:
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-quit:
+				// Nobody is waiting for b anymore
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				var blk *btcutil.Block
+				blk, b.err = fetchBlock(src, b.h, quit, log)
+				if b.err == nil {
+					b.sigs, b.rejected = BlockSignatures(blk)
+				}
+				close(b.done)
+			}
+		}()
+	}
+
+	go func() {
+		res := ScanResult{Reached: start}
+		for b := range pending {
+			if res.Err != nil {
+				// Drain pending, so that the dispatcher exits
+				continue
+			}
+			<-b.done
+			if b.err != nil {
+				res.Err = b.err
+				close(quit)
+				continue
+			}
+			atomic.AddInt64(rejected, int64(b.rejected))
+			for _, s := range b.sigs {
+				sigChan <- s
+			}
+			res.Reached = b.h + 1
+		}
+		close(sigChan)
+
+		// Once the result is out src is not used anymore
+		wg.Wait()
+		result <- res
+	}()
+
+	return
+}
+
+// fetchBlock gets the block at height h from src, retrying with a growing
+// delay. It gives up early if quit is closed.
+func fetchBlock(src Source, h int64, quit <-chan struct{}, log btclog.Logger) (*btcutil.Block, error) {
+	delay := scanRetryDelay
+	for i := 0; ; i++ {
+		blk, err := src.BlockByHeight(h)
+		if err == nil {
+			return blk, nil
+		}
+		if i == scanRetries {
+			return nil, err
+		}
+		log.Warnf("%v - retrying in %v", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			return nil, err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/conformal/btclog"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+func testSigScript(pushes ...[]byte) []byte {
+	b := btcscript.NewScriptBuilder()
+	for _, p := range pushes {
+		b.AddData(p)
+	}
+	return b.Script()
+}
+
+func TestSigScriptSignatures(t *testing.T) {
+	a, b := testKey("a"), testKey("b")
+	pkA := testPubKey(a).SerializeCompressed()
+	pkB := testPubKey(b).SerializeUncompressed()
+	sigA := append(signWithNonce(a, "a", big.NewInt(42)).Signature.Serialize(),
+		byte(btcscript.SigHashAll))
+	sigB := append(signWithNonce(b, "b", big.NewInt(43)).Signature.Serialize(),
+		byte(btcscript.SigHashSingle|btcscript.SigHashAnyOneCanPay))
+
+	addrA, _ := btcutil.NewAddressPubKey(pkA, &btcnet.MainNetParams)
+	addrB, _ := btcutil.NewAddressPubKey(pkB, &btcnet.MainNetParams)
+	twoOfTwo, _ := btcscript.MultiSigScript([]*btcutil.AddressPubKey{addrA, addrB}, 2)
+	oneOfTwo, _ := btcscript.MultiSigScript([]*btcutil.AddressPubKey{addrA, addrB}, 1)
+	p2pk, _ := btcscript.PayToAddrScript(addrA)
+
+	type want struct {
+		data     int
+		hashType byte
+		pubKey   []byte
+	}
+	tests := []struct {
+		name      string
+		sigScript []byte
+		class     btcscript.ScriptClass
+		p2sh      bool
+		sigs      []want
+		rejected  int
+	}{
+		{"pubkeyhash", testSigScript(sigA, pkA), btcscript.PubKeyHashTy, false,
+			[]want{{0, 0x01, pkA}}, 0},
+		{"pubkey", testSigScript(sigB), btcscript.PubKeyTy, false,
+			[]want{{0, 0x83, nil}}, 0},
+		{"multisig", testSigScript(nil, sigA, sigB), btcscript.MultiSigTy, false,
+			[]want{{1, 0x01, nil}, {2, 0x83, nil}}, 0},
+		{"p2sh 2-of-2", testSigScript(nil, sigA, sigB, twoOfTwo), btcscript.MultiSigTy, true,
+			[]want{{1, 0x01, pkA}, {2, 0x83, pkB}}, 0},
+		{"p2sh 1-of-2", testSigScript(nil, sigB, oneOfTwo), btcscript.MultiSigTy, true,
+			[]want{{1, 0x83, nil}}, 0},
+		{"p2sh pubkey", testSigScript(sigA, p2pk), btcscript.PubKeyTy, true,
+			[]want{{0, 0x01, pkA}}, 0},
+		{"junk", testSigScript([]byte("junk"), sigA, pkA), 0, false, nil, 1},
+		{"two sigs", testSigScript(sigA, sigB), 0, false, nil, 2},
+		{"too many sigs", testSigScript(nil, sigA, sigB, oneOfTwo), btcscript.MultiSigTy, false,
+			[]want{{1, 0x01, nil}, {2, 0x83, nil}}, 0},
+	}
+
+	for _, test := range tests {
+		sigs, rejected := SigScriptSignatures(test.sigScript)
+		if rejected != test.rejected {
+			t.Errorf("%v: rejected %v, want %v", test.name, rejected, test.rejected)
+		}
+		if len(sigs) != len(test.sigs) {
+			t.Errorf("%v: found %v signatures, want %v", test.name, len(sigs), len(test.sigs))
+			continue
+		}
+		for i, s := range sigs {
+			w := test.sigs[i]
+			if s.Data != w.data || s.HashType != w.hashType || !bytes.Equal(s.PubKey, w.pubKey) {
+				t.Errorf("%v: signature %v is %v/%#x/%x, want %v/%#x/%x", test.name, i,
+					s.Data, s.HashType, s.PubKey, w.data, w.hashType, w.pubKey)
+			}
+			if s.Class != test.class || s.P2SH != test.p2sh {
+				t.Errorf("%v: signature %v is %v (p2sh %v), want %v (p2sh %v)", test.name, i,
+					s.Class, s.P2SH, test.class, test.p2sh)
+			}
+		}
+	}
+}
+
+func TestBlockSignatures(t *testing.T) {
+	privKey := testKey("a")
+	v := signWithNonce(privKey, "a", big.NewInt(42))
+	der := append(v.Signature.Serialize(), byte(btcscript.SigHashAll))
+
+	coinbase := btcwire.NewMsgTx()
+	coinbase.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0xffffffff),
+		testSigScript(der)))
+	coinbase.AddTxOut(btcwire.NewTxOut(50e8, []byte{btcscript.OP_TRUE}))
+
+	tx := testSpend()
+	tx.TxIn[0].SignatureScript = testSigScript([]byte("junk"), der)
+	tx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{2}, 1), nil))
+	tx.TxIn[1].SignatureScript = testSigScript(der, testPubKey(privKey).SerializeCompressed())
+
+	msg := btcwire.NewMsgBlock(btcwire.NewBlockHeader(&btcwire.ShaHash{}, &btcwire.ShaHash{}, 0, 0))
+	msg.AddTransaction(coinbase)
+	msg.AddTransaction(tx)
+	blk := btcutil.NewBlock(msg)
+	blk.SetHeight(5)
+
+	sigs, rejected := BlockSignatures(blk)
+	if rejected != 1 {
+		t.Errorf("rejected %v pushes, want 1", rejected)
+	}
+	if len(sigs) != 1 {
+		t.Fatalf("found %v signatures, want 1", len(sigs))
+	}
+	s := sigs[0]
+	if s.H != 5 || s.Tx != 1 || s.TxIn != 1 || s.Data != 0 {
+		t.Errorf("wrong position %v/%v/%v/%v", s.H, s.Tx, s.TxIn, s.Data)
+	}
+	if s.Sig.R.Cmp(v.Signature.R) != 0 || s.Sig.S.Cmp(v.Signature.S) != 0 {
+		t.Errorf("wrong signature")
+	}
+}
+
+// scanSource is a Source with a signature in every block, which takes
+// longer to fetch the lower blocks, and fails to fetch the block at fail.
+type scanSource struct {
+	Source
+	sig  []byte
+	fail int64
+}
+
+func (s *scanSource) BlockByHeight(height int64) (*btcutil.Block, error) {
+	if height == s.fail {
+		return nil, errors.New("block not found")
+	}
+	time.Sleep(time.Duration(10-height%10) * time.Millisecond)
+
+	tx := testSpend()
+	tx.TxIn[0].SignatureScript = testSigScript(s.sig)
+	msg := btcwire.NewMsgBlock(btcwire.NewBlockHeader(&btcwire.ShaHash{}, &btcwire.ShaHash{}, 0, 0))
+	msg.AddTransaction(btcwire.NewMsgTx())
+	msg.AddTransaction(tx)
+	blk := btcutil.NewBlock(msg)
+	blk.SetHeight(height)
+	return blk, nil
+}
+
+func TestScan(t *testing.T) {
+	defer func(d time.Duration) { scanRetryDelay = d }(scanRetryDelay)
+	scanRetryDelay = time.Millisecond
+
+	v := signWithNonce(testKey("a"), "a", big.NewInt(42))
+	src := &scanSource{sig: append(v.Signature.Serialize(), byte(btcscript.SigHashAll)), fail: -1}
+
+	sigs, result, _ := Scan(src, 3, 40, 8, nil, btclog.Disabled)
+	next := int64(3)
+	for s := range sigs {
+		if s.H != next {
+			t.Fatalf("got block %v, want %v", s.H, next)
+		}
+		next++
+	}
+	if res := <-result; res.Reached != 40 || res.Err != nil || next != 40 {
+		t.Errorf("scan ended with %+v at block %v", res, next)
+	}
+
+	src.fail = 25
+	sigs, result, _ = Scan(src, 3, 40, 8, nil, btclog.Disabled)
+	next = 3
+	for s := range sigs {
+		if s.H != next {
+			t.Fatalf("got block %v, want %v", s.H, next)
+		}
+		next++
+	}
+	if res := <-result; res.Reached != 25 || res.Err == nil || next != 25 {
+		t.Errorf("scan ended with %+v at block %v, want a failure at 25", res, next)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	sigs, result, _ = Scan(src, 3, 40, 8, stop, btclog.Disabled)
+	for _ = range sigs {
+	}
+	if res := <-result; res.Reached != 3 || res.Err != nil {
+		t.Errorf("stopped scan ended with %+v", res)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	_ "blkfile"
+
+	"github.com/conformal/btcdb"
+	_ "github.com/conformal/btcdb/ldb"
+	_ "github.com/conformal/btcdb/memdb"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// Source is where the blocks and transactions come from. Only the main
+// chain is visible.
+type Source interface {
+	// Tip returns the hash and height of the last block.
+	Tip() (*btcwire.ShaHash, int64, error)
+
+	// BlockByHeight returns the block at height, with the height set.
+	BlockByHeight(height int64) (*btcutil.Block, error)
+
+	// BlockSha returns the hash of the block at height.
+	BlockSha(height int64) (*btcwire.ShaHash, error)
+
+	// BlockHeight returns the height of a block.
+	BlockHeight(sha *btcwire.ShaHash) (int64, error)
+
+	// TxBySha returns the most recent version of a transaction, and the
+	// height of the block that includes it.
+	TxBySha(sha *btcwire.ShaHash) (*btcwire.MsgTx, int64, error)
+
+	Close() error
+}
+
+// SourceConfig selects and configures a Source.
+type SourceConfig struct {
+	// Type is a btcdb backend (leveldb or memdb), blkfile to read the
+	// Bitcoin Core block files, or rpc to ask a btcd node
+	Type string
+
+	// DataDir is the btcd data directory, and NetDir the network
+	// subdirectory in it. For blkfile, DataDir is the blocks directory
+	// or a bootstrap.dat.
+	DataDir string
+	NetDir  string
+	Net     *btcnet.Params
+
+	// The btcd RPC server, and its credentials and TLS certificate
+	RPCServer string
+	RPCUser   string
+	RPCPass   string
+	RPCCert   string
+	NoTLS     bool
+}
+
+// RegisterFlags defines on fs the flags that fill c, with the btcd
+// defaults.
+func (c *SourceConfig) RegisterFlags(fs *flag.FlagSet) {
+	btcdHomeDir := btcutil.AppDataDir("btcd", false)
+	fs.StringVar(&c.DataDir, "datadir", filepath.Join(btcdHomeDir, "data"), "BTCD: Data directory, or the Bitcoin Core block files for blkfile")
+	fs.StringVar(&c.Type, "dbtype", "leveldb", "BTCD: Database backend, blkfile to read the Bitcoin Core block files, or rpc to ask btcd")
+	fs.StringVar(&c.RPCServer, "rpcserver", "localhost:8334", "BTCD: RPC server for -dbtype rpc")
+	fs.StringVar(&c.RPCUser, "rpcuser", "", "BTCD: RPC username")
+	fs.StringVar(&c.RPCPass, "rpcpass", "", "BTCD: RPC password")
+	fs.StringVar(&c.RPCCert, "rpccert", filepath.Join(btcdHomeDir, "rpc.cert"), "BTCD: RPC server certificate")
+	fs.BoolVar(&c.NoTLS, "notls", false, "BTCD: Connect to the RPC server without TLS")
+}
+
+// OpenSource opens the Source selected by c.
+func OpenSource(c *SourceConfig) (Source, error) {
+	switch c.Type {
+	case "rpc":
+		src, err := NewRPCSource(c.RPCServer, c.RPCUser, c.RPCPass, c.RPCCert, c.NoTLS)
+		if err != nil {
+			return nil, err
+		}
+		return src, nil
+	case "blkfile":
+		db, err := btcdb.OpenDB(c.Type, c.DataDir, c.Net)
+		if err != nil {
+			return nil, err
+		}
+		return NewDbSource(db), nil
+	case "memdb":
+		db, err := btcdb.OpenDB(c.Type)
+		if err != nil {
+			return nil, err
+		}
+		return NewDbSource(db), nil
+	}
+
+	blockDbNamePrefix := "blocks"
+	dbName := blockDbNamePrefix + "_" + c.Type
+	if c.Type == "sqlite" {
+		dbName = dbName + ".db"
+	}
+	dbPath := filepath.Join(c.DataDir, c.NetDir, dbName)
+
+	db, err := btcdb.OpenDB(c.Type, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewDbSource(db), nil
+}
+
+// DbSource is a Source backed by a btcdb.Db.
+type DbSource struct {
+	btcdb.Db
+}
+
+// NewDbSource returns a Source reading from db, which it takes ownership
+// of.
+func NewDbSource(db btcdb.Db) *DbSource {
+	return &DbSource{db}
+}
+
+func (s *DbSource) Tip() (*btcwire.ShaHash, int64, error) {
+	return s.NewestSha()
+}
+
+func (s *DbSource) BlockByHeight(height int64) (*btcutil.Block, error) {
+	sha, err := s.FetchBlockShaByHeight(height)
+	if err != nil {
+		return nil, fmt.Errorf("failed FetchBlockShaByHeight(%v): %v", height, err)
+	}
+	blk, err := s.FetchBlockBySha(sha)
+	if err != nil {
+		return nil, fmt.Errorf("failed FetchBlockBySha(%v) - h %v: %v", sha, height, err)
+	}
+	return blk, nil
+}
+
+func (s *DbSource) BlockSha(height int64) (*btcwire.ShaHash, error) {
+	return s.FetchBlockShaByHeight(height)
+}
+
+func (s *DbSource) BlockHeight(sha *btcwire.ShaHash) (int64, error) {
+	return s.FetchBlockHeightBySha(sha)
+}
+
+func (s *DbSource) TxBySha(sha *btcwire.ShaHash) (*btcwire.MsgTx, int64, error) {
+	replies, err := s.FetchTxBySha(sha)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed FetchTxBySha(%v): %v", sha, err)
+	}
+	if len(replies) == 0 {
+		return nil, 0, btcdb.ErrTxShaMissing
+	}
+	last := replies[len(replies)-1]
+	return last.Tx, last.Height, nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/conformal/btcdb"
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// testChain returns a memdb Source with the genesis block and two more,
+// the second spending the coinbase of the first.
+func testChain(t *testing.T) (*DbSource, []*btcwire.MsgBlock) {
+	db, err := btcdb.CreateDB("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := []*btcwire.MsgBlock{btcnet.MainNetParams.GenesisBlock}
+	for i := 1; i <= 2; i++ {
+		prev := blocks[i-1]
+		prevSha, _ := prev.BlockSha()
+		blk := btcwire.NewMsgBlock(btcwire.NewBlockHeader(&prevSha, &btcwire.ShaHash{}, prev.Header.Bits, uint32(i)))
+		coinbase := btcwire.NewMsgTx()
+		coinbase.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0xffffffff), []byte{byte(i)}))
+		coinbase.AddTxOut(btcwire.NewTxOut(50e8, []byte{btcscript.OP_TRUE}))
+		blk.AddTransaction(coinbase)
+		if i == 2 {
+			spent, _ := blocks[1].Transactions[0].TxSha()
+			tx := btcwire.NewMsgTx()
+			tx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&spent, 0), nil))
+			tx.AddTxOut(btcwire.NewTxOut(50e8, []byte{btcscript.OP_TRUE}))
+			blk.AddTransaction(tx)
+		}
+		blocks = append(blocks, blk)
+	}
+
+	for _, blk := range blocks {
+		if _, err := db.InsertBlock(btcutil.NewBlock(blk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewDbSource(db), blocks
+}
+
+func checkSource(t *testing.T, name string, src Source, blocks []*btcwire.MsgBlock) {
+	tipSha, _ := blocks[2].BlockSha()
+	sha, height, err := src.Tip()
+	if err != nil {
+		t.Fatalf("%v: Tip: %v", name, err)
+	}
+	if height != 2 || !sha.IsEqual(&tipSha) {
+		t.Errorf("%v: Tip() = %v, %v, want %v, 2", name, sha, height, tipSha)
+	}
+
+	for h, want := range blocks {
+		wantSha, _ := want.BlockSha()
+		blk, err := src.BlockByHeight(int64(h))
+		if err != nil {
+			t.Fatalf("%v: BlockByHeight(%v): %v", name, h, err)
+		}
+		if sha, _ := blk.Sha(); !sha.IsEqual(&wantSha) || blk.Height() != int64(h) {
+			t.Errorf("%v: BlockByHeight(%v) returned the wrong block", name, h)
+		}
+		if sha, err := src.BlockSha(int64(h)); err != nil || !sha.IsEqual(&wantSha) {
+			t.Errorf("%v: BlockSha(%v) = %v, %v", name, h, sha, err)
+		}
+		if height, err := src.BlockHeight(&wantSha); err != nil || height != int64(h) {
+			t.Errorf("%v: BlockHeight(%v) = %v, %v", name, wantSha, height, err)
+		}
+	}
+
+	txSha, _ := blocks[1].Transactions[0].TxSha()
+	tx, height, err := src.TxBySha(&txSha)
+	if err != nil {
+		t.Fatalf("%v: TxBySha: %v", name, err)
+	}
+	if sha, _ := tx.TxSha(); !sha.IsEqual(&txSha) || height != 1 {
+		t.Errorf("%v: TxBySha returned the wrong transaction", name)
+	}
+}
+
+func TestDbSource(t *testing.T) {
+	src, blocks := testChain(t)
+	defer src.Close()
+	checkSource(t, "memdb", src, blocks)
+}
+
+// rpcStandIn answers the btcd RPC calls used by RPCSource from src.
+func rpcStandIn(t *testing.T, src *DbSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string
+			Params []interface{}
+			Id     interface{}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("bad request: %v", err)
+			return
+		}
+
+		var result interface{}
+		var rpcErr *btcjson.Error
+		switch req.Method {
+		case "getbestblockhash":
+			sha, _, _ := src.Tip()
+			result = sha.String()
+		case "getblockhash":
+			sha, err := src.FetchBlockShaByHeight(int64(req.Params[0].(float64)))
+			if err != nil {
+				rpcErr = &btcjson.ErrOutOfRange
+				break
+			}
+			result = sha.String()
+		case "getblock":
+			sha, _ := btcwire.NewShaHashFromStr(req.Params[0].(string))
+			blk, err := src.FetchBlockBySha(sha)
+			if err != nil {
+				rpcErr = &btcjson.ErrBlockNotFound
+				break
+			}
+			if len(req.Params) > 1 && !req.Params[1].(bool) {
+				buf, _ := blk.Bytes()
+				result = hex.EncodeToString(buf)
+			} else {
+				result = &btcjson.BlockResult{Hash: sha.String(), Height: blk.Height()}
+			}
+		case "getrawtransaction":
+			sha, _ := btcwire.NewShaHashFromStr(req.Params[0].(string))
+			replies, err := src.FetchTxBySha(sha)
+			if err != nil {
+				rpcErr = &btcjson.ErrNoTxInfo
+				break
+			}
+			var buf bytes.Buffer
+			replies[0].Tx.Serialize(&buf)
+			result = &btcjson.TxRawResult{
+				Hex:       hex.EncodeToString(buf.Bytes()),
+				Txid:      sha.String(),
+				BlockHash: replies[0].BlkSha.String(),
+			}
+		default:
+			rpcErr = &btcjson.ErrMethodNotFound
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": result, "error": rpcErr, "id": req.Id,
+		})
+	}
+}
+
+func TestRPCSource(t *testing.T) {
+	db, blocks := testChain(t)
+	defer db.Close()
+
+	server := httptest.NewServer(rpcStandIn(t, db))
+	defer server.Close()
+
+	src, err := OpenSource(&SourceConfig{
+		Type:      "rpc",
+		RPCServer: strings.TrimPrefix(server.URL, "http://"),
+		NoTLS:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	checkSource(t, "rpc", src, blocks)
+
+	if _, err := src.BlockByHeight(3); err == nil {
+		t.Errorf("BlockByHeight succeeded past the tip")
+	}
+}
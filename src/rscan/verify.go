@@ -0,0 +1,279 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+var (
+	ErrCheckSig      = errors.New("OP_CHECKSIG ERROR")
+	ErrCheckSigFail  = errors.New("OP_CHECKSIG FAIL")
+	ErrCheckMultiSig = errors.New("OP_CHECKMULTISIG FAIL")
+)
+
+// Verified is a signature checked against the public key that made it.
+type Verified struct {
+	// SigStr includes the hash type byte
+	SigStr []byte
+	PkStr  []byte
+
+	Signature *btcec.Signature
+	PubKey    *btcec.PublicKey
+
+	// Hash is the signature hash, as computed by OP_CHECKSIG
+	Hash []byte
+}
+
+// Verify computes the hash signed by the signature pushed at push in the
+// input txIn of tx, spending an output with pkScript, and checks it
+// against its public key. P2PKH, P2PK and bare multisig outputs are
+// supported, and P2SH outputs with one of them as the redeem script.
+func Verify(tx *btcwire.MsgTx, txIn int, pkScript []byte, push int) (*Verified, error) {
+	switch t := btcscript.GetScriptClass(pkScript); t {
+	case btcscript.PubKeyHashTy:
+		return verifyPubKeyHash(tx, txIn, pkScript, false)
+	case btcscript.PubKeyTy:
+		return verifyPubKey(tx, txIn, pkScript, pkScript, false)
+	case btcscript.MultiSigTy:
+		return verifyMultiSig(tx, txIn, pkScript, push, false)
+	case btcscript.ScriptHashTy:
+		return verifyScriptHash(tx, txIn, pkScript, push)
+	default:
+		return nil, fmt.Errorf("unsupported pkScript type: %v", btcscript.ScriptClassToName[t])
+	}
+}
+
+// verifyScriptHash handles P2SH outputs, by the class of the redeem
+// script, the last push of the sigScript.
+func verifyScriptHash(tx *btcwire.MsgTx, txIn int, pkScript []byte, push int) (*Verified, error) {
+	sigData, err := btcscript.PushedData(tx.TxIn[txIn].SignatureScript)
+	if err != nil || len(sigData) < 1 {
+		return nil, errors.New("bad P2SH sigScript")
+	}
+	redeemScript := sigData[len(sigData)-1]
+
+	switch t := btcscript.GetScriptClass(redeemScript); t {
+	case btcscript.PubKeyHashTy:
+		return verifyPubKeyHash(tx, txIn, pkScript, true)
+	case btcscript.PubKeyTy:
+		return verifyPubKey(tx, txIn, pkScript, redeemScript, true)
+	case btcscript.MultiSigTy:
+		return verifyMultiSig(tx, txIn, pkScript, push, true)
+	default:
+		return nil, fmt.Errorf("unsupported redeem script type: %v", btcscript.ScriptClassToName[t])
+	}
+}
+
+// runToCheckSig executes the input scripts up to the OP_CHECKSIG, into
+// the redeem script if p2sh is true.
+func runToCheckSig(tx *btcwire.MsgTx, txIn int, pkScript []byte, p2sh bool) (*btcscript.Script, error) {
+	var flags btcscript.ScriptFlags
+	if p2sh {
+		flags = btcscript.ScriptBip16
+	}
+	sigScript := tx.TxIn[txIn].SignatureScript
+	script, err := btcscript.NewScript(sigScript, pkScript, txIn, tx, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed btcscript.NewScript: %v", err)
+	}
+
+	for script.Next() != btcscript.OP_CHECKSIG {
+		done, err := script.Step()
+		if err != nil {
+			return nil, fmt.Errorf("failed Step: %v", err)
+		}
+		if done {
+			return nil, errors.New("no OP_CHECKSIG")
+		}
+	}
+
+	return script, nil
+}
+
+// verifyPubKeyHash handles P2PKH outputs, or P2SH ones with a P2PKH
+// redeem script if p2sh is true.
+func verifyPubKeyHash(tx *btcwire.MsgTx, txIn int, pkScript []byte, p2sh bool) (*Verified, error) {
+	script, err := runToCheckSig(tx, txIn, pkScript, p2sh)
+	if err != nil {
+		return nil, err
+	}
+
+	data := script.GetStack()
+	if len(data) < 2 {
+		return nil, ErrCheckSig
+	}
+
+	return checkSig(script, tx, txIn, data[0], data[1])
+}
+
+// verifyPubKey handles pay-to-pubkey outputs, where the sigScript only
+// carries the signature and the pubkey is in keyScript: the previous
+// output, or the redeem script if p2sh is true.
+func verifyPubKey(tx *btcwire.MsgTx, txIn int, pkScript, keyScript []byte, p2sh bool) (*Verified, error) {
+	pkData, err := btcscript.PushedData(keyScript)
+	if err != nil || len(pkData) != 1 {
+		return nil, errors.New("bad pubkey script")
+	}
+	sigData, err := btcscript.PushedData(tx.TxIn[txIn].SignatureScript)
+	if p2sh && err == nil && len(sigData) == 2 {
+		sigData = sigData[:1]
+	}
+	if err != nil || len(sigData) != 1 {
+		return nil, errors.New("bad pubkey sigScript")
+	}
+
+	script, err := runToCheckSig(tx, txIn, pkScript, p2sh)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkSig(script, tx, txIn, sigData[0], pkData[0])
+}
+
+// checkSig verifies sigStr against pkStr like OP_CHECKSIG would, with
+// script stopped right before it.
+func checkSig(script *btcscript.Script, tx *btcwire.MsgTx, txIn int, sigStr, pkStr []byte) (*Verified, error) {
+	// From github.com/conformal/btcscript/opcode.go
+
+	// Signature actually needs needs to be longer than this, but we need
+	// at least  1 byte for the below. btcec will check full length upon
+	// parsing the signature.
+	if len(sigStr) < 1 {
+		return nil, ErrCheckSig
+	}
+
+	// Trim off hashtype from the signature string.
+	hashType := sigStr[len(sigStr)-1]
+	derSig := sigStr[:len(sigStr)-1]
+
+	// Get script from the last OP_CODESEPARATOR and without any subsequent
+	// OP_CODESEPARATORs
+	subScript := script.SubScript()
+
+	// Unlikely to hit any cases here, but remove the signature from
+	// the script if present.
+	subScript = btcscript.RemoveOpcodeByData(subScript, derSig)
+
+	hash := btcscript.CalcScriptHash(subScript, hashType, tx, txIn)
+
+	pubKey, err := btcec.ParsePubKey(pkStr, btcec.S256())
+	if err != nil {
+		return nil, ErrCheckSig
+	}
+
+	signature, err := btcec.ParseSignature(derSig, btcec.S256())
+	if err != nil {
+		return nil, ErrCheckSig
+	}
+
+	if ok := ecdsa.Verify(pubKey.ToECDSA(), hash, signature.R, signature.S); !ok {
+		return nil, ErrCheckSigFail
+	}
+
+	return &Verified{
+		SigStr:    sigStr,
+		PkStr:     pkStr,
+		Signature: signature,
+		PubKey:    pubKey,
+		Hash:      hash,
+	}, nil
+}
+
+// verifyMultiSig handles bare multisig outputs, and P2SH outputs with a
+// multisig redeem script if p2sh is true. The signature is the one pushed
+// at push, and it's matched to the pubkey it verifies against.
+func verifyMultiSig(tx *btcwire.MsgTx, txIn int, pkScript []byte, push int, p2sh bool) (*Verified, error) {
+	sigScript := tx.TxIn[txIn].SignatureScript
+	sigData, err := btcscript.PushedData(sigScript)
+	if err != nil || len(sigData) < 2 {
+		return nil, errors.New("bad multisig sigScript")
+	}
+
+	// The first push is the dummy value popped by OP_CHECKMULTISIG, and
+	// with P2SH the last one is the redeem script
+	msScript := pkScript
+	sigStrings := sigData[1:]
+	var flags btcscript.ScriptFlags
+	if p2sh {
+		msScript = sigData[len(sigData)-1]
+		sigStrings = sigData[1 : len(sigData)-1]
+		flags = btcscript.ScriptBip16
+	}
+	if push < 1 || push > len(sigStrings) {
+		return nil, fmt.Errorf("push %v is not a signature", push)
+	}
+	sigStr := sigData[push]
+	if len(sigStr) < 1 {
+		return nil, ErrCheckMultiSig
+	}
+
+	pubKeys, err := btcscript.PushedData(msScript)
+	if err != nil {
+		return nil, fmt.Errorf("bad multisig script: %v", err)
+	}
+
+	script, err := btcscript.NewScript(sigScript, pkScript, txIn, tx, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed btcscript.NewScript: %v", err)
+	}
+
+	for {
+		if op := script.Next(); op == btcscript.OP_CHECKMULTISIG ||
+			op == btcscript.OP_CHECKMULTISIGVERIFY {
+			break
+		}
+		done, err := script.Step()
+		if err != nil {
+			return nil, fmt.Errorf("failed Step: %v", err)
+		}
+		if done {
+			return nil, errors.New("no OP_CHECKMULTISIG")
+		}
+	}
+
+	// From github.com/conformal/btcscript/opcode.go
+
+	// Remove any of the signatures that happen to be in the script.
+	subScript := script.SubScript()
+	for _, s := range sigStrings {
+		subScript = btcscript.RemoveOpcodeByData(subScript, s)
+	}
+
+	hashType := sigStr[len(sigStr)-1]
+	hash := btcscript.CalcScriptHash(subScript, hashType, tx, txIn)
+
+	signature, err := btcec.ParseSignature(sigStr[:len(sigStr)-1], btcec.S256())
+	if err != nil {
+		return nil, ErrCheckMultiSig
+	}
+
+	// Find the pubkey the signature belongs to
+	for _, pkStr := range pubKeys {
+		pubKey, err := btcec.ParsePubKey(pkStr, btcec.S256())
+		if err != nil {
+			continue
+		}
+		if !ecdsa.Verify(pubKey.ToECDSA(), hash, signature.R, signature.S) {
+			continue
+		}
+
+		return &Verified{
+			SigStr:    sigStr,
+			PkStr:     pkStr,
+			Signature: signature,
+			PubKey:    pubKey,
+			Hash:      hash,
+		}, nil
+	}
+
+	return nil, ErrCheckMultiSig
+}
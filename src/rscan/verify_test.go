@@ -0,0 +1,129 @@
+// Copyright (c) 2014 Filippo Valsorda
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rscan
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+func testSpend() *btcwire.MsgTx {
+	tx := btcwire.NewMsgTx()
+	prev := btcwire.ShaHash{1}
+	tx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&prev, 0), nil))
+	tx.AddTxOut(btcwire.NewTxOut(1e8, []byte{btcscript.OP_TRUE}))
+	return tx
+}
+
+func TestVerify(t *testing.T) {
+	net := &btcnet.MainNetParams
+	a, b := testKey("a"), testKey("b")
+	pkA, _ := btcutil.NewAddressPubKey(testPubKey(a).SerializeCompressed(), net)
+	pkB, _ := btcutil.NewAddressPubKey(testPubKey(b).SerializeCompressed(), net)
+
+	// Keys are looked up both by P2PKH and pubkey address
+	keys := map[string]*btcec.PrivateKey{
+		pkA.EncodeAddress(): a,
+		pkB.EncodeAddress(): b,
+	}
+
+	kdb := btcscript.KeyClosure(func(addr btcutil.Address) (*ecdsa.PrivateKey, bool, error) {
+		k, ok := keys[addr.EncodeAddress()]
+		if !ok {
+			return nil, false, errors.New("unknown key")
+		}
+		return k.ToECDSA(), true, nil
+	})
+
+	multiSig, err := btcscript.MultiSigScript([]*btcutil.AddressPubKey{pkA, pkB}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptAddr, err := btcutil.NewAddressScriptHash(multiSig, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2pkh, _ := btcscript.PayToAddrScript(pkA.AddressPubKeyHash())
+	p2pk, _ := btcscript.PayToAddrScript(pkA)
+	p2sh, _ := btcscript.PayToAddrScript(scriptAddr)
+
+	// P2SH wrapping the single key scripts
+	scripts := map[string][]byte{scriptAddr.EncodeAddress(): multiSig}
+	wrap := func(redeemScript []byte) []byte {
+		addr, err := btcutil.NewAddressScriptHash(redeemScript, net)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts[addr.EncodeAddress()] = redeemScript
+		pkScript, _ := btcscript.PayToAddrScript(addr)
+		return pkScript
+	}
+	p2shP2PKH, p2shP2PK := wrap(p2pkh), wrap(p2pk)
+
+	sdb := btcscript.ScriptClosure(func(addr btcutil.Address) ([]byte, error) {
+		script, ok := scripts[addr.EncodeAddress()]
+		if !ok {
+			return nil, errors.New("unknown script")
+		}
+		return script, nil
+	})
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		push     int
+		key      *btcec.PrivateKey
+	}{
+		{"p2pkh", p2pkh, 0, a},
+		{"p2pk", p2pk, 0, a},
+		{"multisig a", multiSig, 1, a},
+		{"multisig b", multiSig, 2, b},
+		{"p2sh a", p2sh, 1, a},
+		{"p2sh b", p2sh, 2, b},
+		{"p2sh p2pkh", p2shP2PKH, 0, a},
+		{"p2sh p2pk", p2shP2PK, 0, a},
+	}
+
+	for _, test := range tests {
+		tx := testSpend()
+		sigScript, err := btcscript.SignTxOutput(net, tx, 0, test.pkScript,
+			btcscript.SigHashAll, kdb, sdb, nil)
+		if err != nil {
+			t.Errorf("%v: SignTxOutput: %v", test.name, err)
+			continue
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+
+		v, err := Verify(tx, 0, test.pkScript, test.push)
+		if err != nil {
+			t.Errorf("%v: Verify: %v", test.name, err)
+			continue
+		}
+		if pk := testPubKey(test.key); v.PubKey.X.Cmp(pk.X) != 0 || v.PubKey.Y.Cmp(pk.Y) != 0 {
+			t.Errorf("%v: Verify matched the wrong pubkey", test.name)
+		}
+		if v.SigStr[len(v.SigStr)-1] != byte(btcscript.SigHashAll) {
+			t.Errorf("%v: wrong hash type %v", test.name, v.SigStr[len(v.SigStr)-1])
+		}
+
+		// Changing the transaction changes the hash
+		tx.TxOut[0].Value--
+		if _, err := Verify(tx, 0, test.pkScript, test.push); err == nil {
+			t.Errorf("%v: Verify succeeded on a modified transaction", test.name)
+		}
+	}
+
+	tx := testSpend()
+	if _, err := Verify(tx, 0, []byte{btcscript.OP_TRUE}, 0); err == nil {
+		t.Errorf("Verify succeeded on a nonstandard pkScript")
+	}
+}